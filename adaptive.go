@@ -0,0 +1,148 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AdaptiveLimitOptions configures a AdaptiveLimiter.
+type AdaptiveLimitOptions struct {
+	// InitialLimit is the limit of in-flight statements at first, default 20.
+	InitialLimit int64
+
+	// MinLimit is the lowest limit, default 1.
+	MinLimit int64
+
+	// MaxLimit is the highest limit, default 1000.
+	MaxLimit int64
+
+	// Latency is the target latency, slower statements decrease the limit, default 100ms.
+	Latency time.Duration
+
+	// Backoff multiplies the limit on decrease, default 0.9.
+	Backoff float64
+}
+
+// AdaptiveLimiter bounds the in-flight statements by a limit adjusted to the observed latency, in AIMD style:
+// each statement faster than the target latency increases the limit by 1/limit while the limit is in use,
+// each slower or timed out statement multiplies it by Backoff.
+// The latency of a query is measured to its first result, a query is in flight until its rows are closed.
+// Statements failing with driver.ErrSkip or context.Canceled do not adjust the limit.
+// Statements beyond the limit are rejected with *OverloadError.
+type AdaptiveLimiter struct {
+	options AdaptiveLimitOptions
+
+	sem *semaphore
+
+	mu    sync.Mutex
+	limit float64
+
+	inFlight int64
+	rejected int64
+}
+
+// NewAdaptiveLimiter create a AdaptiveLimiter.
+func NewAdaptiveLimiter(options AdaptiveLimitOptions) *AdaptiveLimiter {
+	if options.MinLimit <= 0 {
+		options.MinLimit = 1
+	}
+	if options.MaxLimit <= 0 {
+		options.MaxLimit = 1000
+	}
+	if options.InitialLimit <= 0 {
+		options.InitialLimit = 20
+	}
+	if options.InitialLimit < options.MinLimit {
+		options.InitialLimit = options.MinLimit
+	}
+	if options.InitialLimit > options.MaxLimit {
+		options.InitialLimit = options.MaxLimit
+	}
+	if options.Latency <= 0 {
+		options.Latency = 100 * time.Millisecond
+	}
+	if options.Backoff <= 0 || options.Backoff >= 1 {
+		options.Backoff = 0.9
+	}
+
+	return &AdaptiveLimiter{
+		options: options,
+		sem:     newSemaphore(options.InitialLimit, 0),
+		limit:   float64(options.InitialLimit),
+	}
+}
+
+// MiddlewareGroup returns the middlewares which limit queries and executes.
+func (limiter *AdaptiveLimiter) MiddlewareGroup() MiddlewareGroup {
+	return MiddlewareGroupChain(admissionMiddlewareGroup(limiter.acquire), aroundMiddlewareGroup(limiter.measure))
+}
+
+// Limit returns the current limit.
+func (limiter *AdaptiveLimiter) Limit() int64 {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	return int64(limiter.limit)
+}
+
+// InFlight returns the number of in-flight statements.
+func (limiter *AdaptiveLimiter) InFlight() int64 {
+	return atomic.LoadInt64(&limiter.inFlight)
+}
+
+// Rejected returns the number of rejected statements.
+func (limiter *AdaptiveLimiter) Rejected() int64 {
+	return atomic.LoadInt64(&limiter.rejected)
+}
+
+func (limiter *AdaptiveLimiter) acquire(ctx context.Context, query string) (func(err error), error) {
+	if !limiter.sem.tryAcquire(1) {
+		atomic.AddInt64(&limiter.rejected, 1)
+		class, _ := QueryClassFromContext(ctx)
+		return nil, &OverloadError{Class: class}
+	}
+
+	atomic.AddInt64(&limiter.inFlight, 1)
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() {
+			atomic.AddInt64(&limiter.inFlight, -1)
+			limiter.sem.release(1)
+		})
+	}, nil
+}
+
+// measure samples the latency of a admitted statement, until its first result.
+func (limiter *AdaptiveLimiter) measure(ctx context.Context, op Operation, query string, namedArg []driver.NamedValue, next func(ctx context.Context) error) error {
+	inFlight := atomic.LoadInt64(&limiter.inFlight)
+	start := time.Now()
+	err := next(ctx)
+	if err == driver.ErrSkip || errors.Is(err, context.Canceled) {
+		return err
+	}
+	limiter.sample(time.Since(start), inFlight, err)
+	return err
+}
+
+// sample adjusts the limit to the latency of a statement, inFlight is the number of statements when it started.
+func (limiter *AdaptiveLimiter) sample(latency time.Duration, inFlight int64, err error) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	limit := limiter.limit
+	if latency > limiter.options.Latency || IsTimeout(err) {
+		limit = math.Max(float64(limiter.options.MinLimit), limit*limiter.options.Backoff)
+	} else if float64(inFlight)*2 >= limit {
+		// grow only while the limit is in use
+		limit = math.Min(float64(limiter.options.MaxLimit), limit+1/limit)
+	}
+
+	if int64(limit) != int64(limiter.limit) {
+		limiter.sem.resize(int64(limit))
+	}
+	limiter.limit = limit
+}
@@ -0,0 +1,115 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wencan/middledriver/internal/fakedriver"
+)
+
+func TestAdaptiveLimiter(t *testing.T) {
+	var delay time.Duration
+	limiter := NewAdaptiveLimiter(AdaptiveLimitOptions{
+		InitialLimit: 4,
+		MaxLimit:     5,
+		Latency:      5 * time.Millisecond,
+		Backoff:      0.5,
+	})
+	dri := Driver{
+		Target: fakedriver.FakeDriver{
+			ExpectedQueryContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
+				time.Sleep(delay)
+				return &fakedriver.FakeRows{ColumnNames: []string{"1"}}, nil
+			},
+			ExpectedExecContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+				time.Sleep(delay)
+				return fakedriver.FakeResult{}, nil
+			},
+		},
+		MiddlewareGroup: limiter.MiddlewareGroup(),
+	}
+	sql.Register("test_adaptive_limiter", dri)
+
+	db, err := sql.Open("test_adaptive_limiter", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.TODO()
+
+	// slow statements decrease the limit
+	delay = 10 * time.Millisecond
+	for idx := 0; idx < 2; idx++ {
+		_, err = db.ExecContext(ctx, "SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if limit := limiter.Limit(); limit != 1 {
+		t.Fatalf("want limit 1, got %d", limit)
+	}
+
+	// statements beyond the limit are rejected
+	rows, err := db.QueryContext(ctx, "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.ExecContext(ctx, "SELECT 1")
+	var overloadErr *OverloadError
+	if !errors.As(err, &overloadErr) || limiter.Rejected() != 1 {
+		t.Fatalf("want OverloadError, got %v with %d rejected", err, limiter.Rejected())
+	}
+	rows.Close()
+
+	// fast statements using the limit increase it
+	delay = 0
+	for idx := 0; idx < 20; idx++ {
+		_, err = db.ExecContext(ctx, "SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if limit := limiter.Limit(); limit < 2 {
+		t.Fatalf("want limit increased, got %d", limit)
+	}
+
+	// the latency of a query is measured to its first result
+	limit := limiter.Limit()
+	rows, err = db.QueryContext(ctx, "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	rows.Close()
+	if got := limiter.Limit(); got < limit {
+		t.Fatalf("want limit %d kept by a fast query read slowly, got %d", limit, got)
+	}
+}
+
+func TestAdaptiveLimiterNotSampled(t *testing.T) {
+	testCases := []struct {
+		Name      string
+		Err       error
+		WantLimit int64
+	}{
+		{Name: "success", Err: nil, WantLimit: 2},
+		{Name: "skip", Err: driver.ErrSkip, WantLimit: 1},
+		{Name: "canceled", Err: context.Canceled, WantLimit: 1},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			limiter := NewAdaptiveLimiter(AdaptiveLimitOptions{InitialLimit: 1})
+			exec := limiter.MiddlewareGroup().ExecContextMiddleware(func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+				return nil, testCase.Err
+			})
+			exec(context.TODO(), "SELECT 1", nil)
+			if limit := limiter.Limit(); limit != testCase.WantLimit {
+				t.Fatalf("want limit %d, got %d", testCase.WantLimit, limit)
+			}
+		})
+	}
+}
@@ -0,0 +1,351 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a circuit of CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets all calls through and measures their failure rate.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen rejects all calls with *CircuitOpenError.
+	CircuitOpen
+
+	// CircuitHalfOpen lets a few probe calls through, their success closes the circuit.
+	CircuitHalfOpen
+)
+
+// String returns the name of the state.
+func (state CircuitState) String() string {
+	switch state {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// CircuitOpenError is returned by CircuitBreaker for the calls rejected by a open circuit.
+type CircuitOpenError struct {
+	// Key is the fingerprint of the circuit, or "" for the global circuit.
+	Key string
+
+	// Until is when the circuit lets probe calls through.
+	Until time.Time
+}
+
+// Error implements error.
+func (err *CircuitOpenError) Error() string {
+	if err.Key == "" {
+		return "middledriver: circuit open"
+	}
+	return "middledriver: circuit open for " + err.Key
+}
+
+// CircuitBreakerOptions configures a CircuitBreaker.
+type CircuitBreakerOptions struct {
+	// Window is the period over which the failure rate is measured, default 10s.
+	Window time.Duration
+
+	// MinRequests is the number of calls in Window before the circuit can open, default 20.
+	MinRequests int
+
+	// FailureRate opens the circuit, default 0.5.
+	FailureRate float64
+
+	// OpenDuration is how long the circuit rejects calls before going half-open, default 5s.
+	OpenDuration time.Duration
+
+	// HalfOpenRequests is the number of probe calls which must succeed to close the circuit, default 1.
+	HalfOpenRequests int
+
+	// PerFingerprint keeps a circuit per query fingerprint instead of a global circuit.
+	// Connecting always goes through the global circuit.
+	PerFingerprint bool
+
+	// IsFailure reports whether a error counts as a failure, default IsTransientError and timeouts,
+	// so errors of the application such as constraint violations do not open the circuit.
+	// The calls which fail with driver.ErrSkip or context.Canceled are not recorded at all.
+	IsFailure func(err error) bool
+
+	// OnStateChange is called when a circuit changes its state, optional.
+	// It is called with the CircuitBreaker locked and must not call it.
+	OnStateChange func(key string, from, to CircuitState)
+}
+
+const (
+	circuitBuckets = 10
+
+	maxCircuits = 4096
+)
+
+// CircuitBreaker fails fast while the database is failing.
+// Its MiddlewareGroup guards queries and executes, and Connector guards connecting.
+type CircuitBreaker struct {
+	options CircuitBreakerOptions
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// NewCircuitBreaker create a CircuitBreaker.
+func NewCircuitBreaker(options CircuitBreakerOptions) *CircuitBreaker {
+	if options.Window <= 0 {
+		options.Window = 10 * time.Second
+	}
+	if options.MinRequests <= 0 {
+		options.MinRequests = 20
+	}
+	if options.FailureRate <= 0 {
+		options.FailureRate = 0.5
+	}
+	if options.OpenDuration <= 0 {
+		options.OpenDuration = 5 * time.Second
+	}
+	if options.HalfOpenRequests <= 0 {
+		options.HalfOpenRequests = 1
+	}
+	if options.IsFailure == nil {
+		options.IsFailure = func(err error) bool {
+			return IsTransientError(err) || errors.Is(err, context.DeadlineExceeded)
+		}
+	}
+
+	return &CircuitBreaker{
+		options:  options,
+		circuits: make(map[string]*circuit),
+	}
+}
+
+// MiddlewareGroup returns the middlewares which guard queries and executes.
+func (breaker *CircuitBreaker) MiddlewareGroup() MiddlewareGroup {
+	return aroundMiddlewareGroup(func(ctx context.Context, op Operation, query string, namedArg []driver.NamedValue, next func(ctx context.Context) error) error {
+		key := ""
+		if breaker.options.PerFingerprint {
+			info, _ := OperationInfoFromContext(ctx)
+			info.Query = originalQuery(ctx, query)
+			key = info.Fingerprint().String()
+		}
+		return breaker.do(key, func() error {
+			return next(ctx)
+		})
+	})
+}
+
+// Connector returns a driver.Connector which guards connecting to target.
+func (breaker *CircuitBreaker) Connector(target driver.Connector) driver.Connector {
+	return breakerConnector{
+		breaker: breaker,
+		target:  target,
+	}
+}
+
+// State returns the state of the circuit of key, a fingerprint or "" for the global circuit.
+func (breaker *CircuitBreaker) State(key string) CircuitState {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	circuit, ok := breaker.circuits[key]
+	if !ok {
+		return CircuitClosed
+	}
+	breaker.refresh(key, circuit, time.Now())
+	return circuit.state
+}
+
+func (breaker *CircuitBreaker) do(key string, call func() error) error {
+	err := breaker.allow(key)
+	if err != nil {
+		return err
+	}
+	err = call()
+	if err == driver.ErrSkip || errors.Is(err, context.Canceled) {
+		// neither a success nor a failure, the probe is given back
+		breaker.release(key)
+		return err
+	}
+	breaker.record(key, breaker.options.IsFailure(err))
+	return err
+}
+
+// circuit returns the circuit of key.
+// When the circuits are full, the closed circuits of fingerprints are evicted,
+// and if there are still too many open ones, the circuit returned for a new key is not kept.
+func (breaker *CircuitBreaker) circuit(key string) *circuit {
+	c, ok := breaker.circuits[key]
+	if ok {
+		return c
+	}
+
+	c = &circuit{}
+	if len(breaker.circuits) >= maxCircuits {
+		for circuitKey, circuit := range breaker.circuits {
+			if circuitKey != "" && circuit.state == CircuitClosed {
+				delete(breaker.circuits, circuitKey)
+			}
+		}
+		if len(breaker.circuits) >= maxCircuits {
+			return c
+		}
+	}
+	breaker.circuits[key] = c
+	return c
+}
+
+// release gives back the probe of a half-open circuit taken by a call which is not recorded.
+func (breaker *CircuitBreaker) release(key string) {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	circuit, ok := breaker.circuits[key]
+	if ok && circuit.state == CircuitHalfOpen && circuit.probes > 0 {
+		circuit.probes--
+	}
+}
+
+func (breaker *CircuitBreaker) allow(key string) error {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	now := time.Now()
+	circuit := breaker.circuit(key)
+	breaker.refresh(key, circuit, now)
+	switch circuit.state {
+	case CircuitOpen:
+		return &CircuitOpenError{
+			Key:   key,
+			Until: circuit.openedAt.Add(breaker.options.OpenDuration),
+		}
+	case CircuitHalfOpen:
+		if circuit.probes >= breaker.options.HalfOpenRequests {
+			return &CircuitOpenError{
+				Key:   key,
+				Until: now,
+			}
+		}
+		circuit.probes++
+	}
+	return nil
+}
+
+func (breaker *CircuitBreaker) record(key string, failed bool) {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	now := time.Now()
+	circuit := breaker.circuit(key)
+	switch circuit.state {
+	case CircuitHalfOpen:
+		if failed {
+			breaker.transit(key, circuit, CircuitOpen, now)
+			return
+		}
+		circuit.successes++
+		if circuit.successes >= breaker.options.HalfOpenRequests {
+			breaker.transit(key, circuit, CircuitClosed, now)
+		}
+
+	case CircuitClosed:
+		requests, failures := circuit.add(now, breaker.options.Window, failed)
+		if requests >= breaker.options.MinRequests && float64(failures) >= breaker.options.FailureRate*float64(requests) {
+			breaker.transit(key, circuit, CircuitOpen, now)
+		}
+	}
+}
+
+// refresh turns a open circuit half-open after OpenDuration.
+func (breaker *CircuitBreaker) refresh(key string, circuit *circuit, now time.Time) {
+	if circuit.state == CircuitOpen && now.Sub(circuit.openedAt) >= breaker.options.OpenDuration {
+		breaker.transit(key, circuit, CircuitHalfOpen, now)
+	}
+}
+
+func (breaker *CircuitBreaker) transit(key string, circuit *circuit, state CircuitState, now time.Time) {
+	from := circuit.state
+	circuit.state = state
+	circuit.probes = 0
+	circuit.successes = 0
+	switch state {
+	case CircuitOpen:
+		circuit.openedAt = now
+	case CircuitClosed:
+		circuit.buckets = [circuitBuckets]circuitBucket{}
+	}
+	if breaker.options.OnStateChange != nil {
+		breaker.options.OnStateChange(key, from, state)
+	}
+}
+
+type circuitBucket struct {
+	// index is the number of the period of the bucket since the epoch
+	index    int64
+	requests int
+	failures int
+}
+
+// circuit is guarded by the mutex of CircuitBreaker.
+type circuit struct {
+	state     CircuitState
+	openedAt  time.Time
+	probes    int
+	successes int
+
+	// buckets are a rolling window split in periods of Window/circuitBuckets
+	buckets [circuitBuckets]circuitBucket
+}
+
+// add counts a call and returns the counts of the window.
+func (circuit *circuit) add(now time.Time, window time.Duration, failed bool) (int, int) {
+	period := int64(window) / circuitBuckets
+	if period <= 0 {
+		period = 1
+	}
+	index := now.UnixNano() / period
+
+	bucket := &circuit.buckets[index%circuitBuckets]
+	if bucket.index != index {
+		*bucket = circuitBucket{index: index}
+	}
+	bucket.requests++
+	if failed {
+		bucket.failures++
+	}
+
+	var requests, failures int
+	for _, bucket := range circuit.buckets {
+		if index-bucket.index < circuitBuckets {
+			requests += bucket.requests
+			failures += bucket.failures
+		}
+	}
+	return requests, failures
+}
+
+type breakerConnector struct {
+	breaker *CircuitBreaker
+	target  driver.Connector
+}
+
+// Connect implements Connector.
+func (connector breakerConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	var conn driver.Conn
+	err := connector.breaker.do("", func() error {
+		var err error
+		conn, err = connector.target.Connect(ctx)
+		return err
+	})
+	return conn, err
+}
+
+// Driver implements Connector.
+func (connector breakerConnector) Driver() driver.Driver {
+	return connector.target.Driver()
+}
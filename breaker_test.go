@@ -0,0 +1,209 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/wencan/middledriver/fingerprint"
+	"github.com/wencan/middledriver/internal/fakedriver"
+)
+
+var errConnReset = &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+
+func TestCircuitBreaker(t *testing.T) {
+	var calls int
+	var replyErr error
+	var transitions []CircuitState
+	breaker := NewCircuitBreaker(CircuitBreakerOptions{
+		MinRequests:  4,
+		OpenDuration: 20 * time.Millisecond,
+		OnStateChange: func(key string, from, to CircuitState) {
+			transitions = append(transitions, to)
+		},
+	})
+	dri := Driver{
+		Target: fakedriver.FakeDriver{
+			ExpectedExecContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+				calls++
+				return fakedriver.FakeResult{}, replyErr
+			},
+		},
+		MiddlewareGroup: breaker.MiddlewareGroup(),
+	}
+	sql.Register("test_circuit_breaker", dri)
+
+	db, err := sql.Open("test_circuit_breaker", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.TODO()
+
+	// 2 failures in 4 calls open the circuit
+	for _, fail := range []bool{false, true, false, true} {
+		replyErr = nil
+		if fail {
+			replyErr = errConnReset
+		}
+		db.ExecContext(ctx, "SELECT 1")
+	}
+	if state := breaker.State(""); state != CircuitOpen {
+		t.Fatalf("want open circuit, got %s", state)
+	}
+
+	_, err = db.ExecContext(ctx, "SELECT 1")
+	var openErr *CircuitOpenError
+	if !errors.As(err, &openErr) || calls != 4 {
+		t.Fatalf("want CircuitOpenError without calling the target, got %v after %d calls", err, calls)
+	}
+
+	// a failed probe opens it again
+	time.Sleep(25 * time.Millisecond)
+	if state := breaker.State(""); state != CircuitHalfOpen {
+		t.Fatalf("want half-open circuit, got %s", state)
+	}
+	db.ExecContext(ctx, "SELECT 1")
+	if state := breaker.State(""); state != CircuitOpen || calls != 5 {
+		t.Fatalf("want circuit open again after 5 calls, got %s after %d calls", state, calls)
+	}
+
+	// a successful probe closes it
+	time.Sleep(25 * time.Millisecond)
+	replyErr = nil
+	_, err = db.ExecContext(ctx, "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := breaker.State(""); state != CircuitClosed {
+		t.Fatalf("want closed circuit, got %s", state)
+	}
+
+	want := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}
+	if len(transitions) != len(want) {
+		t.Fatalf("want transitions %v, got %v", want, transitions)
+	}
+	for idx := range want {
+		if transitions[idx] != want[idx] {
+			t.Fatalf("want transitions %v, got %v", want, transitions)
+		}
+	}
+}
+
+func TestCircuitBreakerPerFingerprint(t *testing.T) {
+	breaker := NewCircuitBreaker(CircuitBreakerOptions{
+		MinRequests:    2,
+		PerFingerprint: true,
+	})
+	dri := Driver{
+		Target: fakedriver.FakeDriver{
+			ExpectedExecContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+				if query == "SELECT * FROM slow" {
+					return nil, context.DeadlineExceeded
+				}
+				return fakedriver.FakeResult{}, nil
+			},
+		},
+		MiddlewareGroup: breaker.MiddlewareGroup(),
+	}
+	sql.Register("test_circuit_breaker_per_fingerprint", dri)
+
+	db, err := sql.Open("test_circuit_breaker_per_fingerprint", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.TODO()
+
+	for idx := 0; idx < 2; idx++ {
+		db.ExecContext(ctx, "SELECT * FROM slow")
+		db.ExecContext(ctx, "SELECT * FROM fast")
+	}
+	if state := breaker.State(fingerprint.Of("SELECT * FROM slow").String()); state != CircuitOpen {
+		t.Fatalf("want open circuit of slow, got %s", state)
+	}
+	_, err = db.ExecContext(ctx, "SELECT * FROM fast")
+	if err != nil {
+		t.Fatalf("want circuit of fast closed, got %v", err)
+	}
+}
+
+func TestCircuitBreakerConnector(t *testing.T) {
+	down := &downConnector{}
+	breaker := NewCircuitBreaker(CircuitBreakerOptions{MinRequests: 2})
+	connector := breaker.Connector(down)
+
+	for idx := 0; idx < 3; idx++ {
+		connector.Connect(context.TODO())
+	}
+	_, err := connector.Connect(context.TODO())
+	var openErr *CircuitOpenError
+	if !errors.As(err, &openErr) || down.calls != 2 {
+		t.Fatalf("want CircuitOpenError after 2 connects, got %v after %d", err, down.calls)
+	}
+}
+
+func TestCircuitBreakerHalfOpenNotRecorded(t *testing.T) {
+	breaker := NewCircuitBreaker(CircuitBreakerOptions{MinRequests: 1, OpenDuration: time.Millisecond})
+	breaker.do("", func() error {
+		return errConnReset
+	})
+	time.Sleep(2 * time.Millisecond)
+	if state := breaker.State(""); state != CircuitHalfOpen {
+		t.Fatalf("want half-open circuit, got %s", state)
+	}
+
+	for _, err := range []error{driver.ErrSkip, context.Canceled} {
+		if got := breaker.do("", func() error { return err }); got != err {
+			t.Fatalf("want %v, got %v", err, got)
+		}
+		if state := breaker.State(""); state != CircuitHalfOpen {
+			t.Fatalf("want circuit half-open after %v, got %s", err, state)
+		}
+	}
+	breaker.do("", func() error { return nil })
+	if state := breaker.State(""); state != CircuitClosed {
+		t.Fatalf("want circuit closed by a probe, got %s", state)
+	}
+}
+
+func TestCircuitBreakerDefaultIsFailure(t *testing.T) {
+	breaker := NewCircuitBreaker(CircuitBreakerOptions{MinRequests: 1})
+	breaker.do("", func() error {
+		return errors.New("duplicate key value violates unique constraint")
+	})
+	if state := breaker.State(""); state != CircuitClosed {
+		t.Fatalf("want circuit closed after a error of the application, got %s", state)
+	}
+
+	breaker.do("", func() error {
+		return context.DeadlineExceeded
+	})
+	if state := breaker.State(""); state != CircuitOpen {
+		t.Fatalf("want circuit open after a timeout, got %s", state)
+	}
+}
+
+func TestCircuitBreakerEviction(t *testing.T) {
+	breaker := NewCircuitBreaker(CircuitBreakerOptions{})
+	breaker.circuits[""] = &circuit{}
+	breaker.circuits["open"] = &circuit{state: CircuitOpen, openedAt: time.Now()}
+	for idx := len(breaker.circuits); idx < maxCircuits; idx++ {
+		breaker.circuits[strconv.Itoa(idx)] = &circuit{}
+	}
+
+	breaker.do("new", func() error { return nil })
+	for _, key := range []string{"", "open", "new"} {
+		if _, ok := breaker.circuits[key]; !ok {
+			t.Fatalf("want circuit %q kept", key)
+		}
+	}
+	if len(breaker.circuits) != 3 {
+		t.Fatalf("want closed circuits of fingerprints evicted, got %d circuits", len(breaker.circuits))
+	}
+}
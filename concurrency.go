@@ -0,0 +1,327 @@
+package middledriver
+
+import (
+	"container/list"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sync"
+)
+
+// OverloadError is returned for the statements shed by a concurrency limit.
+type OverloadError struct {
+	// Class is the query class of the statement.
+	Class string
+
+	// Err is the error of the context if the statement gave up waiting.
+	Err error
+}
+
+// Error implements error.
+func (err *OverloadError) Error() string {
+	message := "middledriver: overloaded"
+	if err.Class != "" {
+		message += " " + err.Class
+	}
+	if err.Err != nil {
+		message += ": " + err.Err.Error()
+	}
+	return message
+}
+
+// Unwrap returns the error of the context.
+func (err *OverloadError) Unwrap() error {
+	return err.Err
+}
+
+type queryClassKey struct{}
+
+// WithQueryClass returns a copy of ctx which sets the query class of its statements, such as "report" or "oltp".
+func WithQueryClass(ctx context.Context, class string) context.Context {
+	return context.WithValue(ctx, queryClassKey{}, class)
+}
+
+// QueryClassFromContext returns the query class set by WithQueryClass.
+func QueryClassFromContext(ctx context.Context) (string, bool) {
+	class, ok := ctx.Value(queryClassKey{}).(string)
+	return class, ok
+}
+
+// ConcurrencyClass limits a query class.
+type ConcurrencyClass struct {
+	// Limit bounds the total weight of the in-flight statements of the class, zero is unlimited.
+	Limit int64
+
+	// Weight is the weight of each statement of the class against the limits, default 1.
+	Weight int64
+}
+
+// ConcurrencyOptions configures ConcurrencyMiddlewareGroup.
+type ConcurrencyOptions struct {
+	// Limit bounds the total weight of all in-flight statements, zero is unlimited.
+	Limit int64
+
+	// Classes limit the query classes, by name.
+	Classes map[string]ConcurrencyClass
+
+	// Classify returns the query class of statements whose context has none, optional.
+	Classify func(info OperationInfo) string
+
+	// MaxQueue bounds the number of statements waiting for each limit, zero is unlimited.
+	MaxQueue int
+}
+
+// ConcurrencyMiddlewareGroup creates a MiddlewareGroup which bounds the in-flight statements, globally and by query class.
+// Statements beyond the limits wait in order until their context is done, then are shed with *OverloadError.
+// A query holds its weight until its rows are closed.
+func ConcurrencyMiddlewareGroup(options ConcurrencyOptions) MiddlewareGroup {
+	limiter := &concurrencyLimiter{
+		options: options,
+		classes: make(map[string]*semaphore),
+	}
+	if options.Limit > 0 {
+		limiter.global = newSemaphore(options.Limit, options.MaxQueue)
+	}
+	for name, class := range options.Classes {
+		if class.Limit > 0 {
+			limiter.classes[name] = newSemaphore(class.Limit, options.MaxQueue)
+		}
+	}
+	return admissionMiddlewareGroup(limiter.acquire)
+}
+
+type concurrencyLimiter struct {
+	options ConcurrencyOptions
+
+	global  *semaphore
+	classes map[string]*semaphore
+}
+
+func (limiter *concurrencyLimiter) class(ctx context.Context, query string) string {
+	class, ok := QueryClassFromContext(ctx)
+	if ok || limiter.options.Classify == nil {
+		return class
+	}
+	info, _ := OperationInfoFromContext(ctx)
+	info.Query = originalQuery(ctx, query)
+	return limiter.options.Classify(info)
+}
+
+// acquire waits for the weight of the statement, the returned func releases it.
+func (limiter *concurrencyLimiter) acquire(ctx context.Context, query string) (func(error), error) {
+	class := limiter.class(ctx, query)
+	weight := limiter.options.Classes[class].Weight
+	if weight <= 0 {
+		weight = 1
+	}
+
+	classSemaphore := limiter.classes[class]
+	err := classSemaphore.acquire(ctx, weight)
+	if err != nil {
+		return nil, &OverloadError{Class: class, Err: ctx.Err()}
+	}
+	err = limiter.global.acquire(ctx, weight)
+	if err != nil {
+		classSemaphore.release(weight)
+		return nil, &OverloadError{Class: class, Err: ctx.Err()}
+	}
+
+	var once sync.Once
+	return func(error) {
+		once.Do(func() {
+			limiter.global.release(weight)
+			classSemaphore.release(weight)
+		})
+	}, nil
+}
+
+// admissionMiddlewareGroup creates a MiddlewareGroup which admits each query and execute through acquire.
+// The returned func is called with the error of the statement when it is done, after its rows are closed for queries.
+func admissionMiddlewareGroup(acquire func(ctx context.Context, query string) (func(err error), error)) MiddlewareGroup {
+	return MiddlewareGroup{
+		QueryContextMiddleware: func(next QueryContextFunc) QueryContextFunc {
+			return func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
+				release, err := acquire(ctx, query)
+				if err != nil {
+					return nil, err
+				}
+				rows, err := next(ctx, query, namedArg)
+				return releaseRows(rows, err, release)
+			}
+		},
+		ExecContextMiddleware: func(next ExecContextFunc) ExecContextFunc {
+			return func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+				release, err := acquire(ctx, query)
+				if err != nil {
+					return nil, err
+				}
+				result, err := next(ctx, query, namedArg)
+				release(err)
+				return result, err
+			}
+		},
+		NewStmtQueryContextMiddleware: func(query string) (StmtQueryContextMiddleware, error) {
+			return func(next StmtQueryContextFunc) StmtQueryContextFunc {
+				return func(ctx context.Context, namedArg []driver.NamedValue) (driver.Rows, error) {
+					release, err := acquire(ctx, query)
+					if err != nil {
+						return nil, err
+					}
+					rows, err := next(ctx, namedArg)
+					return releaseRows(rows, err, release)
+				}
+			}, nil
+		},
+		NewStmtExecContextMiddleware: func(query string) (StmtExecContextMiddleware, error) {
+			return func(next StmtExecContextFunc) StmtExecContextFunc {
+				return func(ctx context.Context, namedArg []driver.NamedValue) (driver.Result, error) {
+					release, err := acquire(ctx, query)
+					if err != nil {
+						return nil, err
+					}
+					result, err := next(ctx, namedArg)
+					release(err)
+					return result, err
+				}
+			}, nil
+		},
+	}
+}
+
+// releaseRows calls release when rows are closed, or at once if the query failed.
+func releaseRows(rows driver.Rows, err error, release func(err error)) (driver.Rows, error) {
+	if err != nil {
+		release(err)
+		return nil, err
+	}
+	return &cancelRows{
+		Rows: rows,
+		ctx:  context.Background(),
+		cancel: func() {
+			release(nil)
+		},
+	}, nil
+}
+
+var errSemaphoreFull = errors.New("semaphore queue full")
+
+// semaphore is a weighted semaphore serving its waiters in order.
+// A nil *semaphore is unlimited.
+type semaphore struct {
+	size     int64
+	maxQueue int
+
+	mu      sync.Mutex
+	cur     int64
+	waiters list.List
+}
+
+type semaphoreWaiter struct {
+	weight int64
+	ready  chan struct{}
+}
+
+func newSemaphore(size int64, maxQueue int) *semaphore {
+	return &semaphore{
+		size:     size,
+		maxQueue: maxQueue,
+	}
+}
+
+func (sem *semaphore) acquire(ctx context.Context, weight int64) error {
+	if sem == nil {
+		return nil
+	}
+	if weight > sem.size {
+		weight = sem.size
+	}
+
+	sem.mu.Lock()
+	if sem.cur+weight <= sem.size && sem.waiters.Len() == 0 {
+		sem.cur += weight
+		sem.mu.Unlock()
+		return nil
+	}
+	if sem.maxQueue > 0 && sem.waiters.Len() >= sem.maxQueue {
+		sem.mu.Unlock()
+		return errSemaphoreFull
+	}
+	waiter := semaphoreWaiter{weight: weight, ready: make(chan struct{})}
+	elem := sem.waiters.PushBack(waiter)
+	sem.mu.Unlock()
+
+	select {
+	case <-waiter.ready:
+		return nil
+	case <-ctx.Done():
+		sem.mu.Lock()
+		select {
+		case <-waiter.ready:
+			// acquired meanwhile
+			sem.cur -= weight
+			sem.notify()
+		default:
+			front := sem.waiters.Front() == elem
+			sem.waiters.Remove(elem)
+			if front {
+				sem.notify()
+			}
+		}
+		sem.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (sem *semaphore) release(weight int64) {
+	if sem == nil {
+		return
+	}
+	if weight > sem.size {
+		weight = sem.size
+	}
+
+	sem.mu.Lock()
+	sem.cur -= weight
+	sem.notify()
+	sem.mu.Unlock()
+}
+
+// tryAcquire acquires weight without waiting.
+func (sem *semaphore) tryAcquire(weight int64) bool {
+	sem.mu.Lock()
+	defer sem.mu.Unlock()
+
+	if weight > sem.size {
+		weight = sem.size
+	}
+	if sem.cur+weight > sem.size || sem.waiters.Len() > 0 {
+		return false
+	}
+	sem.cur += weight
+	return true
+}
+
+// resize changes the size, the weight in use beyond a smaller size is released as usual.
+func (sem *semaphore) resize(size int64) {
+	sem.mu.Lock()
+	sem.size = size
+	sem.notify()
+	sem.mu.Unlock()
+}
+
+// notify wakes the waiters which fit, in order.
+func (sem *semaphore) notify() {
+	for {
+		elem := sem.waiters.Front()
+		if elem == nil {
+			return
+		}
+		waiter := elem.Value.(semaphoreWaiter)
+		if sem.cur+waiter.weight > sem.size {
+			return
+		}
+		sem.cur += waiter.weight
+		sem.waiters.Remove(elem)
+		close(waiter.ready)
+	}
+}
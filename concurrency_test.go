@@ -0,0 +1,127 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wencan/middledriver/internal/fakedriver"
+)
+
+func TestConcurrencyMiddlewareGroup(t *testing.T) {
+	hold := make(chan struct{})
+	started := make(chan struct{}, 1)
+	dri := Driver{
+		Target: fakedriver.FakeDriver{
+			ExpectedQueryContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
+				return &fakedriver.FakeRows{ColumnNames: []string{"1"}}, nil
+			},
+			ExpectedExecContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+				if query == "SELECT * FROM blocking" {
+					started <- struct{}{}
+					<-hold
+				}
+				return fakedriver.FakeResult{}, nil
+			},
+		},
+		MiddlewareGroup: ConcurrencyMiddlewareGroup(ConcurrencyOptions{
+			Limit: 3,
+			Classes: map[string]ConcurrencyClass{
+				"report": {Limit: 2, Weight: 2},
+			},
+		}),
+	}
+	sql.Register("test_concurrency", dri)
+
+	db, err := sql.Open("test_concurrency", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.TODO()
+	report := WithQueryClass(ctx, "report")
+
+	done := make(chan error)
+	go func() {
+		_, err := db.ExecContext(report, "SELECT * FROM blocking")
+		done <- err
+	}()
+	<-started
+
+	// the report class is full
+	timeoutCtx, cancel := context.WithTimeout(report, 10*time.Millisecond)
+	defer cancel()
+	_, err = db.ExecContext(timeoutCtx, "SELECT * FROM reports")
+	var overloadErr *OverloadError
+	if !errors.As(err, &overloadErr) || overloadErr.Class != "report" || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want OverloadError of report, got %v", err)
+	}
+
+	// other classes use the rest of the global limit
+	_, err = db.ExecContext(ctx, "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// queries hold their weight until their rows are closed
+	rows, err := db.QueryContext(ctx, "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeoutCtx, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = db.ExecContext(timeoutCtx, "SELECT 1")
+	if !errors.As(err, &overloadErr) {
+		t.Fatalf("want OverloadError of the global limit, got %v", err)
+	}
+	rows.Close()
+
+	// a waiting statement goes on when the weight is released
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(hold)
+	}()
+	_, err = db.ExecContext(report, "SELECT * FROM reports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSemaphore(t *testing.T) {
+	sem := newSemaphore(2, 1)
+	ctx := context.TODO()
+
+	if err := sem.acquire(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	acquired := make(chan error)
+	go func() {
+		acquired <- sem.acquire(ctx, 1)
+	}()
+	for {
+		sem.mu.Lock()
+		waiting := sem.waiters.Len()
+		sem.mu.Unlock()
+		if waiting == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := sem.acquire(ctx, 1); err != errSemaphoreFull {
+		t.Fatalf("want full queue, got %v", err)
+	}
+	sem.release(2)
+	if err := <-acquired; err != nil {
+		t.Fatal(err)
+	}
+	if sem.cur != 1 {
+		t.Fatalf("want weight 1 in use, got %d", sem.cur)
+	}
+}
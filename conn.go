@@ -2,9 +2,9 @@ package middledriver
 
 import (
 	"context"
-	"database/sql"
 	"database/sql/driver"
 	"errors"
+	"sync/atomic"
 )
 
 // Conn is a connection to a database.
@@ -12,30 +12,96 @@ type Conn struct {
 	driver Driver
 	target driver.Conn
 
+	// endpoint is the name of the endpoint which target was established to
+	endpoint string
+
+	// session is tracked if the driver has a SessionPolicy
+	session *sessionState
+
+	// usage is counted if the driver has a RetirePolicy
+	usage *connUsage
+
+	// inTx is 1 during a transaction
+	inTx *int32
+
 	queryContextFunc QueryContextFunc
 
 	execContextFunc ExecContextFunc
+
+	prepareContextFunc PrepareContextFunc
+
+	beginTxFunc BeginTxFunc
 }
 
-func newConn(target driver.Conn, dri Driver, queryContextMiddleware QueryContextMiddleware, execContextMiddleware ExecContextMiddleware) Conn {
+func newConn(target driver.Conn, dri Driver, queryContextMiddleware QueryContextMiddleware, execContextMiddleware ExecContextMiddleware, prepareContextMiddleware PrepareContextMiddleware, beginTxMiddleware BeginTxMiddleware) Conn {
 	conn := Conn{
 		driver: dri,
 		target: target,
+		inTx:   new(int32),
+	}
+	if dri.SessionPolicy != nil {
+		conn.session = &sessionState{}
+	}
+	if dri.RetirePolicy != nil {
+		conn.usage = newConnUsage()
+	}
+	if endpointer, ok := target.(endpointer); ok {
+		conn.endpoint = endpointer.Endpoint()
+	}
+
+	inner := dri.innerMiddlewareGroup()
+
+	conn.prepareContextFunc = conn.generatePrepareContextFunc()
+	if inner.PrepareContextMiddleware != nil {
+		conn.prepareContextFunc = inner.PrepareContextMiddleware(conn.prepareContextFunc)
+	}
+	if prepareContextMiddleware != nil {
+		conn.prepareContextFunc = prepareContextMiddleware(conn.prepareContextFunc)
 	}
 
 	conn.queryContextFunc = conn.generateQueryContextFunc()
+	if inner.QueryContextMiddleware != nil {
+		conn.queryContextFunc = inner.QueryContextMiddleware(conn.queryContextFunc)
+	}
 	if queryContextMiddleware != nil {
 		conn.queryContextFunc = queryContextMiddleware(conn.queryContextFunc)
 	}
 
 	conn.execContextFunc = conn.generateExecContextFunc()
+	if inner.ExecContextMiddleware != nil {
+		conn.execContextFunc = inner.ExecContextMiddleware(conn.execContextFunc)
+	}
 	if execContextMiddleware != nil {
 		conn.execContextFunc = execContextMiddleware(conn.execContextFunc)
 	}
 
+	conn.beginTxFunc = conn.generateBeginTxFunc()
+	if beginTxMiddleware != nil {
+		conn.beginTxFunc = beginTxMiddleware(conn.beginTxFunc)
+	}
+
+	dri.Stats.connOpened()
+
 	return conn
 }
 
+// finished records a query or execute of the connection or its statements.
+func (conn Conn) finished(query string, err error) {
+	conn.driver.Stats.finished(err)
+	conn.track(query, err)
+	conn.usage.add(err)
+}
+
+func (conn Conn) operationInfo(op Operation, query string) OperationInfo {
+	return OperationInfo{
+		Operation: op,
+		Query:     query,
+		Dialect:   conn.driver.Dialect,
+		Endpoint:  conn.endpoint,
+		InTx:      atomic.LoadInt32(conn.inTx) == 1,
+	}
+}
+
 // Ping implements Pinger.
 func (conn Conn) Ping(ctx context.Context) error {
 	pinger, ok := conn.target.(driver.Pinger)
@@ -43,7 +109,8 @@ func (conn Conn) Ping(ctx context.Context) error {
 		return pinger.Ping(ctx)
 	}
 
-	_, err := conn.QueryContext(ctx, "SELECT ?", []driver.NamedValue{{Value: 1}})
+	// without arguments, the placeholder style of the target does not matter
+	_, err := conn.QueryContext(ctx, "SELECT 1", nil)
 	return err
 }
 
@@ -52,34 +119,39 @@ func (conn Conn) Prepare(query string) (driver.Stmt, error) {
 	return nil, errors.New("Please update Go to 1.8+ version")
 }
 
-// PrepareContext implements ConnPrepareContext.
-func (conn Conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
-	connPrepareContext, ok := conn.target.(driver.ConnPrepareContext)
-	if ok {
-		stmtTarget, err := connPrepareContext.PrepareContext(ctx, query)
-		if err != nil {
-			return nil, err
-		}
-		return newStmt(stmtTarget, conn, query, conn.driver.MiddlewareGroup.NewStmtQueryContextMiddleware, conn.driver.MiddlewareGroup.NewStmtExecContextMiddleware)
-	}
+type preparedQueryKey struct{}
 
-	if ctx.Done() != nil {
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		default:
+func (conn Conn) generatePrepareContextFunc() PrepareContextFunc {
+	return func(ctx context.Context, query string) (driver.Stmt, error) {
+		prepared, ok := ctx.Value(preparedQueryKey{}).(*string)
+		if ok {
+			*prepared = query
 		}
+		return ctxDriverPrepare(ctx, conn.target, query)
 	}
+}
 
-	stmtTarget, err := conn.target.Prepare(query)
+// PrepareContext implements ConnPrepareContext.
+func (conn Conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	// the query which the target prepares, after the middlewares
+	prepared := query
+	ctx = context.WithValue(ctx, preparedQueryKey{}, &prepared)
+	stmtTarget, err := conn.prepareContextFunc(withOperationInfo(ctx, conn.operationInfo(OperationPrepare, query)), query)
+	if err != nil {
+		conn.driver.Stats.failed(err)
+		return nil, err
+	}
+	stmt, err := newStmt(stmtTarget, conn, query, conn.driver.MiddlewareGroup.NewStmtQueryContextMiddleware, conn.driver.MiddlewareGroup.NewStmtExecContextMiddleware)
 	if err != nil {
 		return nil, err
 	}
-	return newStmt(stmtTarget, conn, query, conn.driver.MiddlewareGroup.NewStmtQueryContextMiddleware, conn.driver.MiddlewareGroup.NewStmtExecContextMiddleware)
+	stmt.rewritten = prepared != query && !sameTokens(prepared, query, conn.driver.Dialect)
+	return stmt, nil
 }
 
 // Close implements Conn.
 func (conn Conn) Close() error {
+	conn.driver.Stats.connClosed()
 	return conn.target.Close()
 }
 
@@ -88,33 +160,24 @@ func (conn Conn) Begin() (driver.Tx, error) {
 	return nil, errors.New("Please update Go to 1.8+ version")
 }
 
-// BeginTx implements ConnBeginTx.
-func (conn Conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
-	connBeginTx, ok := conn.target.(driver.ConnBeginTx)
-	if ok {
-		return connBeginTx.BeginTx(ctx, opts)
-	}
-
-	if opts.Isolation != driver.IsolationLevel(int(sql.LevelDefault)) {
-		return nil, errors.New("not support non-default isolation level")
-	}
-	if opts.ReadOnly {
-		return nil, errors.New("not support read-only transactions")
+func (conn Conn) generateBeginTxFunc() BeginTxFunc {
+	_, beginTx := conn.target.(driver.ConnBeginTx)
+	return func(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+		if optional, _ := ctx.Value(optionalReadOnlyKey{}).(bool); optional && !beginTx {
+			opts.ReadOnly = false
+		}
+		return ctxDriverBegin(ctx, conn.target, opts)
 	}
+}
 
-	txTarget, err := conn.target.Begin()
-	if ctx.Done() == nil {
-		return txTarget, err
-	}
-	if err == nil {
-		select {
-		case <-ctx.Done():
-			txTarget.Rollback()
-			return nil, ctx.Err()
-		default:
-		}
+// BeginTx implements ConnBeginTx.
+func (conn Conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	txTarget, err := conn.beginTxFunc(withOperationInfo(ctx, conn.operationInfo(OperationBegin, "")), opts)
+	if err != nil {
+		conn.driver.Stats.failed(err)
+		return nil, err
 	}
-	return txTarget, err
+	return newTx(txTarget, conn), nil
 }
 
 func (conn Conn) generateQueryContextFunc() QueryContextFunc {
@@ -139,20 +202,34 @@ func (conn Conn) generateQueryContextFunc() QueryContextFunc {
 		}
 	}
 
+	// the middlewares of the connection already rewrote the query and its arguments,
+	// so the statement is prepared by the target, bypassing the middlewares of statements.
 	return func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
-		stmt, err := conn.PrepareContext(ctx, query)
+		stmt, err := ctxDriverPrepare(ctx, conn.target, query)
 		if err != nil {
 			return nil, err
 		}
-		defer stmt.Close()
-		stmtContext := stmt.(Stmt)
-		return stmtContext.QueryContext(ctx, namedArg)
+		rows, err := ctxDriverStmtQuery(ctx, stmt, namedArg)
+		if err != nil {
+			stmt.Close()
+			return nil, err
+		}
+		return &cancelRows{
+			Rows: rows,
+			ctx:  context.Background(),
+			cancel: func() {
+				stmt.Close()
+			},
+		}, nil
 	}
 }
 
 // QueryContext implements QueryerContext.
 func (conn Conn) QueryContext(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
-	return conn.queryContextFunc(ctx, query, namedArg)
+	conn.driver.Stats.queryStarted()
+	rows, err := conn.queryContextFunc(withOperationInfo(ctx, conn.operationInfo(OperationQuery, query)), query, namedArg)
+	conn.finished(query, err)
+	return rows, err
 }
 
 func (conn Conn) generateExecContextFunc() ExecContextFunc {
@@ -177,18 +254,22 @@ func (conn Conn) generateExecContextFunc() ExecContextFunc {
 		}
 	}
 
+	// the middlewares of the connection already rewrote the query and its arguments,
+	// so the statement is prepared by the target, bypassing the middlewares of statements.
 	return func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
-		stmt, err := conn.PrepareContext(ctx, query)
+		stmt, err := ctxDriverPrepare(ctx, conn.target, query)
 		if err != nil {
 			return nil, err
 		}
 		defer stmt.Close()
-		stmtContext := stmt.(Stmt)
-		return stmtContext.ExecContext(ctx, namedArg)
+		return ctxDriverStmtExec(ctx, stmt, namedArg)
 	}
 }
 
 // ExecContext implements ExecerContext.
 func (conn Conn) ExecContext(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
-	return conn.execContextFunc(ctx, query, namedArg)
+	conn.driver.Stats.execStarted()
+	result, err := conn.execContextFunc(withOperationInfo(ctx, conn.operationInfo(OperationExec, query)), query, namedArg)
+	conn.finished(query, err)
+	return result, err
 }
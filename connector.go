@@ -3,6 +3,7 @@ package middledriver
 import (
 	"context"
 	"database/sql/driver"
+	"sync/atomic"
 )
 
 // Connector represents a driver in a fixed configuration and can create any number of equivalent Conns for use by multiple goroutines.
@@ -14,12 +15,24 @@ type Connector struct {
 
 // Connect implements Connector.
 func (connector Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	connTarget, err := connector.driver.ConnectRetry.connect(ctx, connector.connect)
+	if err != nil {
+		connector.driver.Stats.failed(err)
+		return nil, err
+	}
+	conn := newConn(connTarget, connector.driver, connector.driver.MiddlewareGroup.QueryContextMiddleware, connector.driver.MiddlewareGroup.ExecContextMiddleware, connector.driver.MiddlewareGroup.PrepareContextMiddleware, connector.driver.MiddlewareGroup.BeginTxMiddleware)
+
+	err = connector.driver.initConn(ctx, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (connector Connector) connect(ctx context.Context) (driver.Conn, error) {
 	if connector.target != nil {
-		connTarget, err := connector.target.Connect(ctx)
-		if err != nil {
-			return nil, err
-		}
-		return newConn(connTarget, connector.driver, connector.driver.MiddlewareGroup.QueryContextMiddleware, connector.driver.MiddlewareGroup.ExecContextMiddleware), nil
+		return connector.target.Connect(ctx)
 	}
 
 	select {
@@ -28,14 +41,94 @@ func (connector Connector) Connect(ctx context.Context) (driver.Conn, error) {
 	default:
 	}
 
-	connTarget, err := connector.driver.Target.Open(connector.name)
+	return connector.driver.Target.Open(connector.name)
+}
+
+// initConn runs InitStatements and OnConnect on a new connection.
+// InitStatements are executed on the target directly, bypassing the middlewares.
+func (dri Driver) initConn(ctx context.Context, conn Conn) error {
+	for _, query := range dri.InitStatements {
+		err := execTarget(ctx, conn.target, query)
+		if err != nil {
+			dri.Stats.failed(err)
+			return err
+		}
+	}
+
+	if dri.OnConnect != nil {
+		err := dri.OnConnect(ctx, conn)
+		if err != nil {
+			dri.Stats.failed(err)
+			return err
+		}
+	}
+
+	// the state left by the initialization is the baseline of the session
+	if conn.session != nil {
+		atomic.StoreInt32(&conn.session.changed, 0)
+	}
+	return nil
+}
+
+// execTarget executes query without arguments on target, through a statement if target cannot execute directly.
+func execTarget(ctx context.Context, target driver.Conn, query string) error {
+	_, err := ctxDriverExec(ctx, target, query, nil)
+	if err != driver.ErrSkip {
+		return err
+	}
+
+	stmt, err := ctxDriverPrepare(ctx, target, query)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return newConn(connTarget, connector.driver, connector.driver.MiddlewareGroup.QueryContextMiddleware, connector.driver.MiddlewareGroup.ExecContextMiddleware), nil
+	defer stmt.Close()
+	_, err = ctxDriverStmtExec(ctx, stmt, nil)
+	return err
 }
 
 // Driver implements Connector.
 func (connector Connector) Driver() driver.Driver {
 	return connector.driver
 }
+
+// NewConnector create a Connector which wraps the connections of target with the middlewares of dri.
+func (dri Driver) NewConnector(target driver.Connector) Connector {
+	return Connector{
+		driver: dri,
+		target: target,
+	}
+}
+
+// DSNConnector returns a driver.Connector which opens name with dri.
+func DSNConnector(dri driver.Driver, name string) (driver.Connector, error) {
+	driverContext, ok := dri.(driver.DriverContext)
+	if ok {
+		return driverContext.OpenConnector(name)
+	}
+
+	return dsnConnector{
+		driver: dri,
+		name:   name,
+	}, nil
+}
+
+type dsnConnector struct {
+	driver driver.Driver
+	name   string
+}
+
+// Connect implements Connector.
+func (connector dsnConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	return connector.driver.Open(connector.name)
+}
+
+// Driver implements Connector.
+func (connector dsnConnector) Driver() driver.Driver {
+	return connector.driver
+}
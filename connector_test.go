@@ -0,0 +1,119 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestInitStatements(t *testing.T) {
+	dir, paths := newSQLiteBackends(t, "init")
+	defer os.RemoveAll(dir)
+	target, err := DSNConnector(&sqlite3.SQLiteDriver{}, paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var called int
+	var executed []string
+	dri := Driver{
+		Target:         &sqlite3.SQLiteDriver{},
+		InitStatements: []string{"PRAGMA foreign_keys = ON", "PRAGMA busy_timeout = 1234"},
+		OnConnect: func(ctx context.Context, conn driver.Conn) error {
+			called++
+			_, err := conn.(driver.ExecerContext).ExecContext(ctx, "PRAGMA cache_size = 100", nil)
+			return err
+		},
+		MiddlewareGroup: MiddlewareGroup{
+			ExecContextMiddleware: func(next ExecContextFunc) ExecContextFunc {
+				return func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+					executed = append(executed, query)
+					return next(ctx, query, namedArg)
+				}
+			},
+		},
+	}
+	db := sql.OpenDB(dri.NewConnector(target))
+	defer db.Close()
+	ctx := context.TODO()
+
+	conns := make([]*sql.Conn, 2)
+	for idx := range conns {
+		conns[idx], err = db.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conns[idx].Close()
+
+		for pragma, want := range map[string]int{"foreign_keys": 1, "busy_timeout": 1234, "cache_size": 100} {
+			var got int
+			err = conns[idx].QueryRowContext(ctx, "PRAGMA "+pragma).Scan(&got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Fatalf("want %s = %d on connection %d, got %d", pragma, want, idx, got)
+			}
+		}
+	}
+	if called != 2 {
+		t.Fatalf("want OnConnect called for 2 connections, got %d", called)
+	}
+	if want := []string{"PRAGMA cache_size = 100", "PRAGMA cache_size = 100"}; !reflect.DeepEqual(want, executed) {
+		t.Fatalf("want only OnConnect executes through the middlewares %+v, got %+v", want, executed)
+	}
+}
+
+func TestInitFailure(t *testing.T) {
+	dir, paths := newSQLiteBackends(t, "init")
+	defer os.RemoveAll(dir)
+	target, err := DSNConnector(&sqlite3.SQLiteDriver{}, paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	errInit := errors.New("init failed")
+
+	testCases := []struct {
+		Name string
+		dri  Driver
+	}{
+		{
+			Name: "statement",
+			dri: Driver{
+				Target:         &sqlite3.SQLiteDriver{},
+				InitStatements: []string{"SET search_path = app"},
+			},
+		},
+		{
+			Name: "callback",
+			dri: Driver{
+				Target: &sqlite3.SQLiteDriver{},
+				OnConnect: func(ctx context.Context, conn driver.Conn) error {
+					return errInit
+				},
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			testCase.dri.Stats = NewStats()
+			db := sql.OpenDB(testCase.dri.NewConnector(target))
+			defer db.Close()
+
+			err := db.Ping()
+			if err == nil {
+				t.Fatal("want error")
+			}
+			snapshot := testCase.dri.Stats.Snapshot()
+			if snapshot.OpenConns != 0 || snapshot.Errors != 1 {
+				t.Fatalf("want failed connection closed and counted once, got %+v", snapshot)
+			}
+		})
+	}
+}
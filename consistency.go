@@ -0,0 +1,73 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync/atomic"
+	"time"
+)
+
+// ConsistencyToken pins the reads of a request to the primary once the request wrote.
+// It is safe for concurrent use.
+type ConsistencyToken struct {
+	window time.Duration
+
+	// lastWrite is the UnixNano time of the last write, 0 if none.
+	lastWrite int64
+}
+
+type consistencyTokenKey struct{}
+
+// WithConsistencyToken returns a copy of ctx carrying a new ConsistencyToken.
+// After a write, reads are pinned to the primary for window, or until ctx is discarded if window is 0.
+func WithConsistencyToken(ctx context.Context, window time.Duration) context.Context {
+	return context.WithValue(ctx, consistencyTokenKey{}, &ConsistencyToken{window: window})
+}
+
+// ConsistencyTokenFromContext returns the ConsistencyToken carried by ctx.
+func ConsistencyTokenFromContext(ctx context.Context) (*ConsistencyToken, bool) {
+	token, ok := ctx.Value(consistencyTokenKey{}).(*ConsistencyToken)
+	return token, ok
+}
+
+// MarkWrite records a write now.
+func (token *ConsistencyToken) MarkWrite() {
+	atomic.StoreInt64(&token.lastWrite, time.Now().UnixNano())
+}
+
+// Pinned reports whether reads must go to the primary.
+func (token *ConsistencyToken) Pinned() bool {
+	lastWrite := atomic.LoadInt64(&token.lastWrite)
+	if lastWrite == 0 {
+		return false
+	}
+	return token.window <= 0 || time.Since(time.Unix(0, lastWrite)) < token.window
+}
+
+// ConsistencyMiddlewareGroup creates the middlewares which mark the ConsistencyToken of the context
+// after every successful execute and every successful query which is not read-only.
+// Router sends the reads of a marked context to the primary, and marks the writes it routes by itself,
+// so this group is only needed for the writes which do not go through a Router.
+func ConsistencyMiddlewareGroup() MiddlewareGroup {
+	return aroundMiddlewareGroup(func(ctx context.Context, op Operation, query string, namedArg []driver.NamedValue, next func(ctx context.Context) error) error {
+		err := next(ctx)
+		if err != nil {
+			return err
+		}
+
+		token, ok := ConsistencyTokenFromContext(ctx)
+		if !ok {
+			return nil
+		}
+		switch op {
+		case OperationExec, OperationStmtExec:
+			token.MarkWrite()
+		default:
+			info, ok := OperationInfoFromContext(ctx)
+			if !ok || !info.Statement().ReadOnly {
+				token.MarkWrite()
+			}
+		}
+		return nil
+	})
+}
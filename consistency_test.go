@@ -0,0 +1,88 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/wencan/middledriver/sqlparse"
+)
+
+func TestConsistencyToken(t *testing.T) {
+	ctx := WithConsistencyToken(context.TODO(), time.Millisecond)
+	token, ok := ConsistencyTokenFromContext(ctx)
+	if !ok {
+		t.Fatal("want token")
+	}
+	if token.Pinned() {
+		t.Fatal("want not pinned before write")
+	}
+	token.MarkWrite()
+	if !token.Pinned() {
+		t.Fatal("want pinned after write")
+	}
+	time.Sleep(2 * time.Millisecond)
+	if token.Pinned() {
+		t.Fatal("want not pinned after window")
+	}
+}
+
+func TestConsistencyMiddlewareGroup(t *testing.T) {
+	dir, paths := newSQLiteBackends(t, "primary", "replica")
+	defer os.RemoveAll(dir)
+
+	primary, err := DSNConnector(&sqlite3.SQLiteDriver{}, paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica, err := DSNConnector(&sqlite3.SQLiteDriver{}, paths[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := NewRouter(primary, replica)
+
+	dri := Driver{
+		Target:          &sqlite3.SQLiteDriver{},
+		Dialect:         sqlparse.DialectSQLite,
+		MiddlewareGroup: ConsistencyMiddlewareGroup(),
+	}
+	db := sql.OpenDB(dri.NewConnector(router))
+	defer db.Close()
+
+	ctx := WithConsistencyToken(context.TODO(), 0)
+	got, err := queryBackend(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "replica" {
+		t.Fatalf("want read from replica before write, got %s", got)
+	}
+
+	_, err = db.ExecContext(ctx, "UPDATE backend SET name = 'written'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = queryBackend(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "written" {
+		t.Fatalf("want read own write, got %s", got)
+	}
+
+	got, err = queryBackend(context.TODO(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "replica" {
+		t.Fatalf("want other request read from replica, got %s", got)
+	}
+
+	want := RouterStats{PinnedReads: 1, ReplicaReads: 2, Writes: 1}
+	if stats := router.Stats(); stats != want {
+		t.Fatalf("want stats %+v, got %+v", want, stats)
+	}
+}
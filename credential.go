@@ -0,0 +1,219 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"sync"
+	"time"
+)
+
+// Credentials are what a CredentialProvider produces, a DSN or a target connector.
+type Credentials struct {
+	// DSN is opened with the driver of CredentialConnector if Connector is nil.
+	DSN string
+
+	Connector driver.Connector
+
+	// Expiry is when the credentials are fetched again, zero expires them after CredentialOptions.TTL.
+	Expiry time.Time
+}
+
+// CredentialProvider fetches the current credentials, such as from a secret manager.
+type CredentialProvider func(ctx context.Context) (Credentials, error)
+
+// CredentialOptions configures a CredentialConnector.
+type CredentialOptions struct {
+	// TTL is how long credentials without Expiry are cached, default 5m.
+	TTL time.Duration
+
+	// StaleOnError keeps using the expired credentials while the provider fails,
+	// the provider is called again after RefetchInterval.
+	StaleOnError bool
+
+	// IsAuthError reports whether a connect error may be caused by rotated credentials,
+	// only such errors fetch the credentials again, default all errors.
+	IsAuthError func(err error) bool
+
+	// RefetchInterval is the least time between two fetches caused by connect errors, default 5s.
+	RefetchInterval time.Duration
+}
+
+// CredentialConnector is a driver.Connector which consults a CredentialProvider on each Connect,
+// so new connections pick up rotated secrets.
+// The credentials are cached until they expire.
+// If connecting with cached credentials fails with a authentication error, they are fetched again
+// and connecting is retried once, at most once per RefetchInterval.
+// The provider is called by one goroutine at a time, the others wait for its credentials.
+// The replaced target connectors which implement io.Closer are closed.
+type CredentialConnector struct {
+	driver   driver.Driver
+	provider CredentialProvider
+	options  CredentialOptions
+
+	mu          sync.Mutex
+	connector   driver.Connector
+	expiry      time.Time
+	lastRefetch time.Time
+
+	// fetching is the fetch in progress, nil if none
+	fetching *credentialFetch
+}
+
+type credentialFetch struct {
+	done   chan struct{}
+	target driver.Connector
+	err    error
+}
+
+// NewCredentialConnector create a CredentialConnector, dri opens the DSNs produced by provider.
+func NewCredentialConnector(dri driver.Driver, provider CredentialProvider, options CredentialOptions) *CredentialConnector {
+	if options.TTL <= 0 {
+		options.TTL = 5 * time.Minute
+	}
+	if options.IsAuthError == nil {
+		options.IsAuthError = func(err error) bool {
+			return true
+		}
+	}
+	if options.RefetchInterval <= 0 {
+		options.RefetchInterval = 5 * time.Second
+	}
+	return &CredentialConnector{
+		driver:   dri,
+		provider: provider,
+		options:  options,
+	}
+}
+
+// Connect implements Connector.
+func (connector *CredentialConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	target, cached, err := connector.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := target.Connect(ctx)
+	if err == nil || !cached || !connector.options.IsAuthError(err) || !connector.refetchable() {
+		return conn, err
+	}
+
+	// the cached credentials may have been rotated
+	target, _, fetchErr := connector.current(ctx)
+	if fetchErr != nil {
+		return nil, err
+	}
+	return target.Connect(ctx)
+}
+
+// Driver implements Connector.
+func (connector *CredentialConnector) Driver() driver.Driver {
+	return connector.driver
+}
+
+// Close implements io.Closer, it closes the current target connector if it implements io.Closer.
+func (connector *CredentialConnector) Close() error {
+	connector.mu.Lock()
+	target := connector.connector
+	connector.connector = nil
+	connector.expiry = time.Time{}
+	connector.mu.Unlock()
+
+	if closer, ok := target.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Invalidate drops the cached credentials, the next Connect fetches them again.
+func (connector *CredentialConnector) Invalidate() {
+	connector.mu.Lock()
+	defer connector.mu.Unlock()
+	connector.expiry = time.Time{}
+}
+
+// refetchable reports whether the credentials may be fetched again after a connect error,
+// and if so drops the cached credentials.
+func (connector *CredentialConnector) refetchable() bool {
+	connector.mu.Lock()
+	defer connector.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(connector.lastRefetch) < connector.options.RefetchInterval {
+		return false
+	}
+	connector.lastRefetch = now
+	connector.expiry = time.Time{}
+	return true
+}
+
+// current returns the target connector of the current credentials, and whether it was cached.
+// The provider is called without the lock held.
+func (connector *CredentialConnector) current(ctx context.Context) (driver.Connector, bool, error) {
+	connector.mu.Lock()
+	if connector.connector != nil && time.Now().Before(connector.expiry) {
+		target := connector.connector
+		connector.mu.Unlock()
+		return target, true, nil
+	}
+
+	fetching := connector.fetching
+	if fetching != nil {
+		connector.mu.Unlock()
+		select {
+		case <-fetching.done:
+			return fetching.target, false, fetching.err
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+	fetching = &credentialFetch{done: make(chan struct{})}
+	connector.fetching = fetching
+	connector.mu.Unlock()
+
+	target, expiry, err := connector.fetch(ctx)
+
+	var replaced driver.Connector
+	connector.mu.Lock()
+	if err == nil {
+		if connector.connector != target {
+			replaced = connector.connector
+		}
+		connector.connector = target
+		connector.expiry = expiry
+	} else if connector.options.StaleOnError && connector.connector != nil {
+		// back off, the provider is not called by every Connect while it fails
+		target, err = connector.connector, nil
+		connector.expiry = time.Now().Add(connector.options.RefetchInterval)
+	}
+	connector.fetching = nil
+	connector.mu.Unlock()
+
+	if closer, ok := replaced.(io.Closer); ok {
+		closer.Close()
+	}
+
+	fetching.target, fetching.err = target, err
+	close(fetching.done)
+	return target, false, err
+}
+
+func (connector *CredentialConnector) fetch(ctx context.Context) (driver.Connector, time.Time, error) {
+	credentials, err := connector.provider(ctx)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	target := credentials.Connector
+	if target == nil {
+		target, err = DSNConnector(connector.driver, credentials.DSN)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+
+	expiry := credentials.Expiry
+	if expiry.IsZero() {
+		expiry = time.Now().Add(connector.options.TTL)
+	}
+	return target, expiry, nil
+}
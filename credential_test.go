@@ -0,0 +1,173 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestCredentialConnector(t *testing.T) {
+	dir, paths := newSQLiteBackends(t, "old", "new")
+	defer os.RemoveAll(dir)
+
+	var calls int
+	credentials := Credentials{DSN: paths[0]}
+	var providerErr error
+	connector := NewCredentialConnector(&sqlite3.SQLiteDriver{}, func(ctx context.Context) (Credentials, error) {
+		calls++
+		return credentials, providerErr
+	}, CredentialOptions{TTL: 50 * time.Millisecond, StaleOnError: true})
+	ctx := context.TODO()
+
+	connectBackend := func() string {
+		conn, err := connector.Connect(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		rows, err := conn.(driver.QueryerContext).QueryContext(ctx, "SELECT name FROM backend", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		dest := make([]driver.Value, 1)
+		err = rows.Next(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dest[0].(string)
+	}
+
+	// cached
+	for idx := 0; idx < 2; idx++ {
+		if got := connectBackend(); got != "old" || calls != 1 {
+			t.Fatalf("want old with 1 fetch, got %s with %d", got, calls)
+		}
+	}
+
+	// rotated
+	credentials = Credentials{DSN: paths[1]}
+	connector.Invalidate()
+	if got := connectBackend(); got != "new" || calls != 2 {
+		t.Fatalf("want new with 2 fetches, got %s with %d", got, calls)
+	}
+
+	// expired, the provider fails
+	time.Sleep(60 * time.Millisecond)
+	providerErr = errors.New("secret manager unavailable")
+	if got := connectBackend(); got != "new" || calls != 3 {
+		t.Fatalf("want stale new with 3 fetches, got %s with %d", got, calls)
+	}
+	// the failing provider is not called again before RefetchInterval
+	if got := connectBackend(); got != "new" || calls != 3 {
+		t.Fatalf("want stale new without fetching, got %s with %d", got, calls)
+	}
+}
+
+// closingConnector counts the calls of Close.
+type closingConnector struct {
+	driver.Connector
+
+	closed int32
+}
+
+func (connector *closingConnector) Close() error {
+	atomic.AddInt32(&connector.closed, 1)
+	return nil
+}
+
+func TestCredentialConnectorClose(t *testing.T) {
+	oldTarget, newTarget := &closingConnector{Connector: &downConnector{}}, &closingConnector{Connector: &downConnector{}}
+	credentials := Credentials{Connector: oldTarget}
+	connector := NewCredentialConnector(&sqlite3.SQLiteDriver{}, func(ctx context.Context) (Credentials, error) {
+		return credentials, nil
+	}, CredentialOptions{})
+	ctx := context.TODO()
+
+	connector.Connect(ctx)
+	credentials = Credentials{Connector: newTarget}
+	connector.Invalidate()
+	connector.Connect(ctx)
+	if oldTarget.closed != 1 || newTarget.closed != 0 {
+		t.Fatalf("want the replaced connector closed, got %d and %d closes", oldTarget.closed, newTarget.closed)
+	}
+
+	err := connector.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldTarget.closed != 1 || newTarget.closed != 1 {
+		t.Fatalf("want the current connector closed, got %d and %d closes", oldTarget.closed, newTarget.closed)
+	}
+}
+
+func TestCredentialConnectorRefetch(t *testing.T) {
+	dir, paths := newSQLiteBackends(t, "rotated")
+	defer os.RemoveAll(dir)
+
+	down := &downConnector{}
+	credentials := Credentials{Connector: down}
+	var calls int
+	connector := NewCredentialConnector(&sqlite3.SQLiteDriver{}, func(ctx context.Context) (Credentials, error) {
+		calls++
+		return credentials, nil
+	}, CredentialOptions{})
+	ctx := context.TODO()
+
+	// fresh credentials are not fetched again
+	_, err := connector.Connect(ctx)
+	if err == nil || calls != 1 {
+		t.Fatalf("want error with 1 fetch, got %v with %d", err, calls)
+	}
+
+	// cached credentials which no longer work are fetched again
+	credentials = Credentials{DSN: paths[0]}
+	conn, err := connector.Connect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if calls != 2 || down.calls != 2 {
+		t.Fatalf("want 2 fetches and 2 failed connects, got %d and %d", calls, down.calls)
+	}
+}
+
+func TestCredentialConnectorRefetchLimited(t *testing.T) {
+	errAuth := errors.New("access denied")
+	down := &downConnector{}
+	var calls int
+	provider := func(ctx context.Context) (Credentials, error) {
+		calls++
+		return Credentials{Connector: down}, nil
+	}
+	ctx := context.TODO()
+
+	// connect errors other than authentication errors keep the credentials
+	connector := NewCredentialConnector(&sqlite3.SQLiteDriver{}, provider, CredentialOptions{
+		IsAuthError: func(err error) bool {
+			return err == errAuth
+		},
+	})
+	for idx := 0; idx < 3; idx++ {
+		connector.Connect(ctx)
+	}
+	if calls != 1 {
+		t.Fatalf("want 1 fetch without authentication errors, got %d", calls)
+	}
+
+	// authentication errors fetch again once per RefetchInterval
+	calls = 0
+	connector = NewCredentialConnector(&sqlite3.SQLiteDriver{}, provider, CredentialOptions{RefetchInterval: time.Hour})
+	for idx := 0; idx < 3; idx++ {
+		connector.Connect(ctx)
+	}
+	if calls != 2 {
+		t.Fatalf("want 2 fetches within RefetchInterval, got %d", calls)
+	}
+}
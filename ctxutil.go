@@ -1,6 +1,8 @@
 package middledriver
 
 import (
+	"context"
+	"database/sql"
 	"database/sql/driver"
 	"errors"
 )
@@ -15,3 +17,130 @@ func namedValueToValue(named []driver.NamedValue) ([]driver.Value, error) {
 	}
 	return dargs, nil
 }
+
+func ctxDriverPrepare(ctx context.Context, ci driver.Conn, query string) (driver.Stmt, error) {
+	connPrepareContext, ok := ci.(driver.ConnPrepareContext)
+	if ok {
+		return connPrepareContext.PrepareContext(ctx, query)
+	}
+
+	if ctx.Done() != nil {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+	}
+
+	return ci.Prepare(query)
+}
+
+func ctxDriverBegin(ctx context.Context, ci driver.Conn, opts driver.TxOptions) (driver.Tx, error) {
+	connBeginTx, ok := ci.(driver.ConnBeginTx)
+	if ok {
+		return connBeginTx.BeginTx(ctx, opts)
+	}
+
+	if opts.Isolation != driver.IsolationLevel(int(sql.LevelDefault)) {
+		return nil, errors.New("not support non-default isolation level")
+	}
+	if opts.ReadOnly {
+		return nil, errors.New("not support read-only transactions")
+	}
+
+	txTarget, err := ci.Begin()
+	if ctx.Done() == nil {
+		return txTarget, err
+	}
+	if err == nil {
+		select {
+		case <-ctx.Done():
+			txTarget.Rollback()
+			return nil, ctx.Err()
+		default:
+		}
+	}
+	return txTarget, err
+}
+
+// ctxDriverQuery returns driver.ErrSkip if ci implements neither QueryerContext nor Queryer.
+func ctxDriverQuery(ctx context.Context, ci driver.Conn, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
+	queryerContext, ok := ci.(driver.QueryerContext)
+	if ok {
+		return queryerContext.QueryContext(ctx, query, namedArg)
+	}
+
+	queryer, ok := ci.(driver.Queryer)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	arg, err := namedValueToValue(namedArg)
+	if err != nil {
+		return nil, err
+	}
+	return queryer.Query(query, arg)
+}
+
+// ctxDriverExec returns driver.ErrSkip if ci implements neither ExecerContext nor Execer.
+func ctxDriverExec(ctx context.Context, ci driver.Conn, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+	execerContext, ok := ci.(driver.ExecerContext)
+	if ok {
+		return execerContext.ExecContext(ctx, query, namedArg)
+	}
+
+	execer, ok := ci.(driver.Execer)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	arg, err := namedValueToValue(namedArg)
+	if err != nil {
+		return nil, err
+	}
+	return execer.Exec(query, arg)
+}
+
+func ctxDriverStmtQuery(ctx context.Context, si driver.Stmt, namedArg []driver.NamedValue) (driver.Rows, error) {
+	stmtQueryContext, ok := si.(driver.StmtQueryContext)
+	if ok {
+		return stmtQueryContext.QueryContext(ctx, namedArg)
+	}
+
+	arg, err := namedValueToValue(namedArg)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	return si.Query(arg)
+}
+
+func ctxDriverStmtExec(ctx context.Context, si driver.Stmt, namedArg []driver.NamedValue) (driver.Result, error) {
+	stmtExecContext, ok := si.(driver.StmtExecContext)
+	if ok {
+		return stmtExecContext.ExecContext(ctx, namedArg)
+	}
+
+	arg, err := namedValueToValue(namedArg)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	return si.Exec(arg)
+}
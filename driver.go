@@ -1,8 +1,11 @@
 package middledriver
 
 import (
+	"context"
 	"database/sql/driver"
 	"errors"
+
+	"github.com/wencan/middledriver/sqlparse"
 )
 
 // Driver is the interface that must be implemented by a database driver.
@@ -10,6 +13,42 @@ type Driver struct {
 	Target driver.Driver
 
 	MiddlewareGroup MiddlewareGroup
+
+	// Dialect is the SQL dialect of the target, used to classify statements.
+	Dialect sqlparse.Dialect
+
+	// EmulateNamedParameters rewrites :name and @name placeholders into the positional style of Dialect,
+	// and reorders the arguments to match, for targets which do not support named parameters.
+	// Under DialectMySQL @name is a user variable, under DialectSQLServer @p1 is the first argument.
+	EmulateNamedParameters bool
+
+	// Stats counts the lifecycle of connections, statements and transactions, optional.
+	Stats *Stats
+
+	// ConnectRetry retries connecting to the target on transient errors, optional.
+	ConnectRetry *ConnectRetryPolicy
+
+	// InitStatements are executed on each new connection before it is handed to the pool,
+	// such as PRAGMA busy_timeout = 5000 or SET TIME ZONE 'UTC'. They bypass the middlewares.
+	InitStatements []string
+
+	// OnConnect is called with each new connection after InitStatements, optional.
+	// The connection is closed and not handed to the pool if InitStatements or OnConnect fails.
+	OnConnect func(ctx context.Context, conn driver.Conn) error
+
+	// SessionPolicy restores or discards pooled connections whose session state was changed, optional.
+	SessionPolicy *SessionPolicy
+
+	// RetirePolicy makes database/sql discard connections which are too old, too used or failed too often, optional.
+	RetirePolicy *RetirePolicy
+}
+
+// innerMiddlewareGroup returns the middlewares which run between MiddlewareGroup and the target.
+func (dri Driver) innerMiddlewareGroup() MiddlewareGroup {
+	if dri.EmulateNamedParameters {
+		return namedParametersMiddlewareGroup(dri.Dialect)
+	}
+	return MiddlewareGroup{}
 }
 
 // Open implements Driver.
@@ -0,0 +1,236 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNoEndpoint is returned by NewFailoverConnector without endpoints.
+var ErrNoEndpoint = errors.New("middledriver: no endpoint")
+
+// FailoverEndpoint is a named target of FailoverConnector.
+type FailoverEndpoint struct {
+	// Name identifies the endpoint in OperationInfo.Endpoint and EndpointHealth.
+	Name string
+
+	Connector driver.Connector
+}
+
+// FailoverOptions configures a FailoverConnector.
+type FailoverOptions struct {
+	// Backoff is how long a endpoint is marked down after a failure, doubled for each consecutive failure, default 1s.
+	Backoff time.Duration
+
+	// MaxBackoff caps Backoff, default 1m.
+	MaxBackoff time.Duration
+}
+
+// EndpointHealth is the health of a endpoint of FailoverConnector.
+type EndpointHealth struct {
+	Name string
+
+	// Up reports whether the endpoint is tried in order of priority.
+	Up bool
+
+	// Failures is the number of consecutive failures.
+	Failures int
+
+	// DownUntil is when a down endpoint is tried again.
+	DownUntil time.Time
+}
+
+// FailoverConnector is a driver.Connector which connects to the first healthy endpoint in order of priority.
+// A endpoint is marked down with exponential backoff when connecting to it fails.
+// A pooled connection returning driver.ErrBadConn does not mark its endpoint down by itself,
+// it may be stale only, but database/sql then connects again, and that fresh connect fails if the endpoint is down.
+// If all endpoints are down, all are tried.
+//
+// The endpoint which a connection was established to is reported by OperationInfo.Endpoint.
+type FailoverConnector struct {
+	endpoints []*failoverEndpoint
+	options   FailoverOptions
+}
+
+type failoverEndpoint struct {
+	FailoverEndpoint
+
+	mu        sync.Mutex
+	failures  int
+	downUntil time.Time
+}
+
+// NewFailoverConnector create a FailoverConnector, endpoints are in order of priority.
+// It returns ErrNoEndpoint without endpoints.
+func NewFailoverConnector(endpoints []FailoverEndpoint, options FailoverOptions) (*FailoverConnector, error) {
+	if len(endpoints) == 0 {
+		return nil, ErrNoEndpoint
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = time.Second
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = time.Minute
+	}
+
+	connector := &FailoverConnector{
+		options: options,
+	}
+	for _, endpoint := range endpoints {
+		connector.endpoints = append(connector.endpoints, &failoverEndpoint{FailoverEndpoint: endpoint})
+	}
+	return connector, nil
+}
+
+// Connect implements Connector.
+func (connector *FailoverConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	now := time.Now()
+	var up, down []*failoverEndpoint
+	for _, endpoint := range connector.endpoints {
+		if endpoint.up(now) {
+			up = append(up, endpoint)
+		} else {
+			down = append(down, endpoint)
+		}
+	}
+
+	var err error
+	for _, endpoint := range append(up, down...) {
+		var target driver.Conn
+		target, err = endpoint.Connector.Connect(ctx)
+		if err == nil {
+			endpoint.succeeded()
+			return &failoverConn{
+				Conn:     target,
+				endpoint: endpoint,
+			}, nil
+		}
+		// the endpoint is not to blame for a canceled or expired context
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		connector.failed(endpoint)
+	}
+	return nil, err
+}
+
+// Driver implements Connector.
+func (connector *FailoverConnector) Driver() driver.Driver {
+	return connector.endpoints[0].Connector.Driver()
+}
+
+// Health returns the health of the endpoints, in order of priority.
+func (connector *FailoverConnector) Health() []EndpointHealth {
+	now := time.Now()
+	healths := make([]EndpointHealth, 0, len(connector.endpoints))
+	for _, endpoint := range connector.endpoints {
+		endpoint.mu.Lock()
+		healths = append(healths, EndpointHealth{
+			Name:      endpoint.Name,
+			Up:        !now.Before(endpoint.downUntil),
+			Failures:  endpoint.failures,
+			DownUntil: endpoint.downUntil,
+		})
+		endpoint.mu.Unlock()
+	}
+	return healths
+}
+
+func (connector *FailoverConnector) failed(endpoint *failoverEndpoint) {
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+
+	backoff := connector.options.Backoff
+	for idx := 0; idx < endpoint.failures && backoff < connector.options.MaxBackoff; idx++ {
+		backoff *= 2
+	}
+	if backoff > connector.options.MaxBackoff {
+		backoff = connector.options.MaxBackoff
+	}
+	endpoint.failures++
+	endpoint.downUntil = time.Now().Add(backoff)
+}
+
+func (endpoint *failoverEndpoint) up(now time.Time) bool {
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+	return !now.Before(endpoint.downUntil)
+}
+
+func (endpoint *failoverEndpoint) succeeded() {
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+	endpoint.failures = 0
+	endpoint.downUntil = time.Time{}
+}
+
+// endpointer is implemented by connections which know their endpoint.
+type endpointer interface {
+	Endpoint() string
+}
+
+type failoverConn struct {
+	driver.Conn
+
+	endpoint *failoverEndpoint
+}
+
+// Endpoint returns the name of the endpoint of the connection.
+func (conn *failoverConn) Endpoint() string {
+	return conn.endpoint.Name
+}
+
+// PrepareContext implements ConnPrepareContext.
+func (conn *failoverConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return ctxDriverPrepare(ctx, conn.Conn, query)
+}
+
+// BeginTx implements ConnBeginTx.
+func (conn *failoverConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return ctxDriverBegin(ctx, conn.Conn, opts)
+}
+
+// QueryContext implements QueryerContext.
+func (conn *failoverConn) QueryContext(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
+	return ctxDriverQuery(ctx, conn.Conn, query, namedArg)
+}
+
+// ExecContext implements ExecerContext.
+func (conn *failoverConn) ExecContext(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+	return ctxDriverExec(ctx, conn.Conn, query, namedArg)
+}
+
+// Ping implements Pinger.
+func (conn *failoverConn) Ping(ctx context.Context) error {
+	pinger, ok := conn.Conn.(driver.Pinger)
+	if !ok {
+		return nil
+	}
+	return pinger.Ping(ctx)
+}
+
+// ResetSession implements SessionResetter.
+func (conn *failoverConn) ResetSession(ctx context.Context) error {
+	resetter, ok := conn.Conn.(driver.SessionResetter)
+	if !ok {
+		return nil
+	}
+	return resetter.ResetSession(ctx)
+}
+
+// IsValid implements Validator.
+func (conn *failoverConn) IsValid() bool {
+	validator, ok := conn.Conn.(driver.Validator)
+	return !ok || validator.IsValid()
+}
+
+// CheckNamedValue implements NamedValueChecker.
+func (conn *failoverConn) CheckNamedValue(nv *driver.NamedValue) error {
+	checker, ok := conn.Conn.(driver.NamedValueChecker)
+	if !ok {
+		checker = defaultNamedValueChecker{}
+	}
+	return checker.CheckNamedValue(nv)
+}
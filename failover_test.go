@@ -0,0 +1,147 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+type downConnector struct {
+	calls int32
+}
+
+func (connector *downConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	atomic.AddInt32(&connector.calls, 1)
+	return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+}
+
+func (connector *downConnector) Driver() driver.Driver {
+	return &sqlite3.SQLiteDriver{}
+}
+
+func TestFailoverConnector(t *testing.T) {
+	dir, paths := newSQLiteBackends(t, "secondary")
+	defer os.RemoveAll(dir)
+	secondary, err := DSNConnector(&sqlite3.SQLiteDriver{}, paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	down := &downConnector{}
+	failover, err := NewFailoverConnector([]FailoverEndpoint{
+		{Name: "primary", Connector: down},
+		{Name: "secondary", Connector: secondary},
+	}, FailoverOptions{Backoff: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var endpoint atomic.Value
+	dri := Driver{
+		Target: &sqlite3.SQLiteDriver{},
+		MiddlewareGroup: MiddlewareGroup{
+			QueryContextMiddleware: func(next QueryContextFunc) QueryContextFunc {
+				return func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
+					info, _ := OperationInfoFromContext(ctx)
+					endpoint.Store(info.Endpoint)
+					return next(ctx, query, namedArg)
+				}
+			},
+		},
+	}
+	db := sql.OpenDB(dri.NewConnector(failover))
+	defer db.Close()
+	db.SetMaxIdleConns(0)
+	ctx := context.TODO()
+
+	got, err := queryBackend(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "secondary" || endpoint.Load() != "secondary" {
+		t.Fatalf("want served by secondary, got %s by %v", got, endpoint.Load())
+	}
+
+	health := failover.Health()
+	if health[0].Up || health[0].Failures != 1 || !health[1].Up {
+		t.Fatalf("want primary down, got %+v", health)
+	}
+
+	// the primary is skipped while it is down
+	_, err = queryBackend(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := atomic.LoadInt32(&down.calls); calls != 1 {
+		t.Fatalf("want primary tried once, got %d", calls)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	_, err = queryBackend(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := atomic.LoadInt32(&down.calls); calls != 2 {
+		t.Fatalf("want primary tried again after backoff, got %d", calls)
+	}
+	health = failover.Health()
+	if health[0].Failures != 2 || health[0].DownUntil.Sub(time.Now()) <= 50*time.Millisecond {
+		t.Fatalf("want backoff doubled, got %+v", health[0])
+	}
+}
+
+func TestFailoverConnectorAllDown(t *testing.T) {
+	first, second := &downConnector{}, &downConnector{}
+	failover, err := NewFailoverConnector([]FailoverEndpoint{
+		{Name: "first", Connector: first},
+		{Name: "second", Connector: second},
+	}, FailoverOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for idx := 0; idx < 2; idx++ {
+		_, err := failover.Connect(context.TODO())
+		if err == nil {
+			t.Fatal("want error")
+		}
+	}
+	if first.calls != 2 || second.calls != 2 {
+		t.Fatalf("want all endpoints tried when all are down, got %d, %d", first.calls, second.calls)
+	}
+}
+
+func TestFailoverConnectorCanceled(t *testing.T) {
+	down := &downConnector{}
+	failover, err := NewFailoverConnector([]FailoverEndpoint{
+		{Name: "down", Connector: down},
+	}, FailoverOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	_, err = failover.Connect(ctx)
+	if err != context.Canceled {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+	if health := failover.Health(); !health[0].Up || health[0].Failures != 0 {
+		t.Fatalf("want endpoint up after a canceled connect, got %+v", health[0])
+	}
+}
+
+func TestNewFailoverConnectorWithoutEndpoints(t *testing.T) {
+	_, err := NewFailoverConnector(nil, FailoverOptions{})
+	if err != ErrNoEndpoint {
+		t.Fatalf("want ErrNoEndpoint, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+// Package fingerprint normalizes SQL text into a stable identity of the statement.
+//
+// Normalization removes comments, replaces literals and placeholders with ?,
+// collapses IN lists and multi-row VALUES, lowercases unquoted words and collapses whitespace,
+// so that queries which differ only in their parameters share a fingerprint.
+// Executable comments, such as MySQL /*! */, are kept with their content as the database runs them.
+package fingerprint
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/wencan/middledriver/internal/boundedcache"
+	"github.com/wencan/middledriver/sqlparse"
+)
+
+// Fingerprint is the identity of a statement.
+type Fingerprint struct {
+	// Normalized is the normalized query.
+	Normalized string
+
+	// Hash is the 64-bit FNV-1a hash of Normalized.
+	Hash uint64
+}
+
+// String returns the hash as 16 hex digits.
+func (fp Fingerprint) String() string {
+	s := strconv.FormatUint(fp.Hash, 16)
+	return strings.Repeat("0", 16-len(s)) + s
+}
+
+// New computes the fingerprint of query without caching.
+func New(query string) Fingerprint {
+	return NewDialect(query, sqlparse.DialectGeneric)
+}
+
+// NewDialect computes the fingerprint of query, tokenized following dialect, without caching.
+func NewDialect(query string, dialect sqlparse.Dialect) Fingerprint {
+	normalized := NormalizeDialect(query, dialect)
+	hash := fnv.New64a()
+	hash.Write([]byte(normalized))
+	return Fingerprint{
+		Normalized: normalized,
+		Hash:       hash.Sum64(),
+	}
+}
+
+// Cache caches fingerprints per query string.
+type Cache struct {
+	fingerprints *boundedcache.Cache
+}
+
+type cacheKey struct {
+	query   string
+	dialect sqlparse.Dialect
+}
+
+// NewCache create a Cache holding at most size fingerprints.
+func NewCache(size int) *Cache {
+	return &Cache{
+		fingerprints: boundedcache.New(size),
+	}
+}
+
+// Of returns the fingerprint of query.
+func (cache *Cache) Of(query string) Fingerprint {
+	return cache.OfDialect(query, sqlparse.DialectGeneric)
+}
+
+// OfDialect returns the fingerprint of query, tokenized following dialect.
+func (cache *Cache) OfDialect(query string, dialect sqlparse.Dialect) Fingerprint {
+	key := cacheKey{query: query, dialect: dialect}
+	if fp, ok := cache.fingerprints.Load(key); ok {
+		return fp.(Fingerprint)
+	}
+
+	fp := NewDialect(query, dialect)
+	cache.fingerprints.Store(key, fp)
+	return fp
+}
+
+var defaultCache = NewCache(4096)
+
+// Of returns the fingerprint of query from the default cache.
+func Of(query string) Fingerprint {
+	return defaultCache.Of(query)
+}
+
+// OfDialect returns the fingerprint of query, tokenized following dialect, from the default cache.
+func OfDialect(query string, dialect sqlparse.Dialect) Fingerprint {
+	return defaultCache.OfDialect(query, dialect)
+}
@@ -0,0 +1,95 @@
+package fingerprint
+
+import (
+	"testing"
+
+	"github.com/wencan/middledriver/sqlparse"
+)
+
+func TestNormalize(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Query string
+		Want  string
+	}{
+		{
+			Name:  "test_normalize_literals",
+			Query: "SELECT name FROM users WHERE age = 18 AND name = 'Tom' AND score > -1.5e3",
+			Want:  "select name from users where age = ? and name = ? and score > - ?",
+		},
+		{
+			Name:  "test_normalize_whitespace_comments",
+			Query: "SELECT  *\n\tFROM users -- all users\n WHERE id = ? /* by id */",
+			Want:  "select * from users where id = ?",
+		},
+		{
+			Name:  "test_normalize_placeholders",
+			Query: "SELECT * FROM users WHERE a = $1 AND b = :name AND c = @p1 AND d = ?2 AND e = @@version AND f = g::int",
+			Want:  "select * from users where a = ? and b = ? and c = ? and d = ? and e = @@version and f = g::int",
+		},
+		{
+			Name:  "test_normalize_in_list",
+			Query: "SELECT * FROM users WHERE id IN (1, 2, 3) AND age IN (?)",
+			Want:  "select * from users where id in (...) and age in (...)",
+		},
+		{
+			Name:  "test_normalize_values",
+			Query: "INSERT INTO users (id, name) VALUES (1, 'a'), (2, 'b'), (?, ?)",
+			Want:  "insert into users (id, name) values (?, ?)",
+		},
+		{
+			Name:  "test_normalize_quoted",
+			Query: `SELECT "Name", ` + "`Age`" + ` FROM "Users" WHERE note = 'it''s' AND body = $tag$a 'b'$tag$ AND x = E'\n'`,
+			Want:  `select "Name", ` + "`Age`" + ` from "Users" where note = ? and body = ? and x = ?`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			got := Normalize(testCase.Query)
+			if got != testCase.Want {
+				t.Fatalf("want %s, got %s", testCase.Want, got)
+			}
+		})
+	}
+}
+
+func TestOf(t *testing.T) {
+	a := Of("SELECT * FROM users WHERE id IN (1, 2)")
+	b := Of("select *  from users where id in (?,?,?)")
+	if a != b {
+		t.Fatalf("want same fingerprint, got %+v and %+v", a, b)
+	}
+	if len(a.String()) != 16 {
+		t.Fatalf("want 16 hex digits, got %s", a.String())
+	}
+	if Of("SELECT * FROM orders") == a {
+		t.Fatal("want different fingerprint")
+	}
+}
+
+func TestOfDialect(t *testing.T) {
+	plain := OfDialect("SELECT name FROM t WHERE id = 1", sqlparse.DialectMySQL)
+	for _, query := range []string{
+		"SELECT name FROM t WHERE id = 1 /*!50000 UNION SELECT password FROM users */",
+		"SELECT name FROM t WHERE id = 1 /*M!100000 UNION SELECT password FROM users */",
+		"SELECT /*+ BKA(t) */ name FROM t WHERE id = 1",
+	} {
+		if fp := OfDialect(query, sqlparse.DialectMySQL); fp == plain {
+			t.Fatalf("want fingerprint of %s different from %s, got %s", query, plain.Normalized, fp.Normalized)
+		}
+	}
+	if fp := OfDialect("SELECT name FROM t WHERE id = 1 /* plain */", sqlparse.DialectMySQL); fp != plain {
+		t.Fatalf("want same fingerprint, got %+v and %+v", fp, plain)
+	}
+}
+
+func TestCache(t *testing.T) {
+	cache := NewCache(2)
+	cache.Of("SELECT 1")
+	cache.Of("SELECT 2")
+	cache.Of("SELECT 3")
+	if cache.fingerprints.Len() != 1 {
+		t.Fatalf("want 1 cached fingerprint, got %d", cache.fingerprints.Len())
+	}
+}
@@ -0,0 +1,144 @@
+package fingerprint
+
+import (
+	"strings"
+
+	"github.com/wencan/middledriver/sqlparse"
+)
+
+// Normalize returns query with comments removed, literals and placeholders replaced by ?,
+// IN lists and repeated VALUES rows collapsed, unquoted words lowercased and whitespace collapsed.
+// The query is tokenized following the generic dialect.
+func Normalize(query string) string {
+	return NormalizeDialect(query, sqlparse.DialectGeneric)
+}
+
+// NormalizeDialect is Normalize with query tokenized following dialect.
+// Executable comments and optimizer hints are kept, the database runs them.
+func NormalizeDialect(query string, dialect sqlparse.Dialect) string {
+	tokens := collapse(scan(query, dialect))
+
+	var builder strings.Builder
+	builder.Grow(len(query))
+	for idx, token := range tokens {
+		if idx > 0 && needSpace(tokens[idx-1], token) {
+			builder.WriteByte(' ')
+		}
+		builder.WriteString(token)
+	}
+	return builder.String()
+}
+
+func needSpace(prev, token string) bool {
+	switch {
+	case prev == "(" || prev == "." || prev == "::":
+		return false
+	case token == ")" || token == "," || token == "." || token == ";" || token == "::":
+		return false
+	}
+	return true
+}
+
+// scan splits query into normalized tokens.
+func scan(query string, dialect sqlparse.Dialect) []string {
+	var tokens []string
+	for _, token := range sqlparse.Tokenize(query, dialect) {
+		switch token.Kind {
+		case sqlparse.TokenWhitespace, sqlparse.TokenComment:
+		case sqlparse.TokenString, sqlparse.TokenNumber, sqlparse.TokenPlaceholder:
+			tokens = append(tokens, "?")
+		case sqlparse.TokenWord:
+			tokens = append(tokens, strings.ToLower(token.Text))
+		default:
+			tokens = append(tokens, token.Text)
+		}
+	}
+	return tokens
+}
+
+// collapse replaces IN lists with (...) and removes repeated rows of VALUES.
+func collapse(tokens []string) []string {
+	result := make([]string, 0, len(tokens))
+	for idx := 0; idx < len(tokens); idx++ {
+		token := tokens[idx]
+		result = append(result, token)
+
+		switch token {
+		case "in":
+			if end, ok := placeholderList(tokens, idx+1); ok {
+				result = append(result, "(...)")
+				idx = end - 1
+			}
+
+		case "values":
+			start := idx + 1
+			end := groupEnd(tokens, start)
+			if end < 0 {
+				continue
+			}
+			row := tokens[start:end]
+			result = append(result, row...)
+			idx = end - 1
+			for idx+1 < len(tokens) && tokens[idx+1] == "," {
+				next := groupEnd(tokens, idx+2)
+				if next < 0 || !equal(tokens[idx+2:next], row) {
+					break
+				}
+				idx = next - 1
+			}
+		}
+	}
+	return result
+}
+
+// placeholderList returns the end of a parenthesized list of placeholders starting at pos.
+func placeholderList(tokens []string, pos int) (int, bool) {
+	if pos >= len(tokens) || tokens[pos] != "(" {
+		return pos, false
+	}
+	for pos++; pos+1 < len(tokens); pos += 2 {
+		if tokens[pos] != "?" {
+			return pos, false
+		}
+		switch tokens[pos+1] {
+		case ")":
+			return pos + 2, true
+		case ",":
+		default:
+			return pos, false
+		}
+	}
+	return pos, false
+}
+
+// groupEnd returns the end of the balanced parenthesized group starting at pos, or -1.
+func groupEnd(tokens []string, pos int) int {
+	if pos >= len(tokens) || tokens[pos] != "(" {
+		return -1
+	}
+	depth := 0
+	for ; pos < len(tokens); pos++ {
+		switch tokens[pos] {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return pos + 1
+			}
+		}
+	}
+	return -1
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,241 @@
+package middledriver
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/wencan/middledriver/fingerprint"
+	"github.com/wencan/middledriver/sqlparse"
+)
+
+// FirewallMode is the mode of a Firewall.
+type FirewallMode int32
+
+const (
+	// FirewallLearning lets all queries through and adds their fingerprints to the allowlist.
+	FirewallLearning FirewallMode = iota
+
+	// FirewallEnforcing rejects the queries whose fingerprints are not in the allowlist with *FirewallError.
+	FirewallEnforcing
+)
+
+// String returns the name of the mode.
+func (mode FirewallMode) String() string {
+	if mode == FirewallEnforcing {
+		return "enforcing"
+	}
+	return "learning"
+}
+
+// FirewallError is returned for the queries rejected by a Firewall.
+type FirewallError struct {
+	Fingerprint fingerprint.Fingerprint
+}
+
+// Error implements error.
+func (err *FirewallError) Error() string {
+	return "middledriver: query not allowed by firewall: " + err.Fingerprint.String()
+}
+
+// FirewallOptions configures a Firewall.
+type FirewallOptions struct {
+	// Mode is the mode at first, default FirewallLearning.
+	Mode FirewallMode
+
+	// MaxFingerprints bounds the fingerprints learned, beyond it new fingerprints are not learned, default 10000.
+	MaxFingerprints int
+
+	// OnReject is called for each rejected query, optional.
+	OnReject func(ctx context.Context, info OperationInfo)
+}
+
+// Firewall lets only known queries reach the database.
+// In learning mode it records the fingerprint of every distinct query,
+// in enforcing mode it rejects the queries whose fingerprints were not learned or loaded.
+// Queries are fingerprinted following the Dialect of the driver, so the content of
+// MySQL executable comments, which the server runs, is part of their fingerprints.
+// The Dialect must match the database: a string literal the database ends elsewhere
+// than the lexer does can hide an injected condition from the fingerprint.
+//
+// The allowlist file has a line per fingerprint, the hash and the normalized query separated by a space,
+// only the hash is matched. Blank lines and lines starting with # are ignored.
+type Firewall struct {
+	options FirewallOptions
+
+	mode int32
+
+	mu      sync.RWMutex
+	allowed map[uint64]string
+}
+
+// NewFirewall create a Firewall with a empty allowlist.
+func NewFirewall(options FirewallOptions) *Firewall {
+	if options.MaxFingerprints <= 0 {
+		options.MaxFingerprints = 10000
+	}
+	return &Firewall{
+		options: options,
+		mode:    int32(options.Mode),
+		allowed: make(map[uint64]string),
+	}
+}
+
+// SetMode changes the mode, it takes effect for the next queries.
+func (firewall *Firewall) SetMode(mode FirewallMode) {
+	atomic.StoreInt32(&firewall.mode, int32(mode))
+}
+
+// Mode returns the current mode.
+func (firewall *Firewall) Mode() FirewallMode {
+	return FirewallMode(atomic.LoadInt32(&firewall.mode))
+}
+
+// MiddlewareGroup returns the middlewares which guard queries and executes.
+func (firewall *Firewall) MiddlewareGroup() MiddlewareGroup {
+	return aroundMiddlewareGroup(func(ctx context.Context, op Operation, query string, namedArg []driver.NamedValue, next func(ctx context.Context) error) error {
+		info, _ := OperationInfoFromContext(ctx)
+		info.Query = originalQuery(ctx, query)
+		fp := fingerprint.OfDialect(info.Query, info.Dialect)
+		if firewall.Mode() == FirewallLearning {
+			firewall.add(fp.Hash, fp.Normalized)
+			return next(ctx)
+		}
+
+		firewall.mu.RLock()
+		_, ok := firewall.allowed[fp.Hash]
+		firewall.mu.RUnlock()
+		if !ok {
+			if firewall.options.OnReject != nil {
+				firewall.options.OnReject(ctx, info)
+			}
+			return &FirewallError{Fingerprint: fp}
+		}
+		return next(ctx)
+	})
+}
+
+// Allow adds the fingerprint of query to the allowlist, dialect should be the Dialect of the driver.
+func (firewall *Firewall) Allow(query string, dialect sqlparse.Dialect) {
+	fp := fingerprint.OfDialect(query, dialect)
+	firewall.add(fp.Hash, fp.Normalized)
+}
+
+// Allowlist returns the fingerprints in the allowlist, ordered by hash.
+func (firewall *Firewall) Allowlist() []fingerprint.Fingerprint {
+	firewall.mu.RLock()
+	fps := make([]fingerprint.Fingerprint, 0, len(firewall.allowed))
+	for hash, normalized := range firewall.allowed {
+		fps = append(fps, fingerprint.Fingerprint{Normalized: normalized, Hash: hash})
+	}
+	firewall.mu.RUnlock()
+
+	sort.Slice(fps, func(i, j int) bool {
+		return fps[i].Hash < fps[j].Hash
+	})
+	return fps
+}
+
+func (firewall *Firewall) add(hash uint64, normalized string) {
+	firewall.mu.RLock()
+	_, ok := firewall.allowed[hash]
+	full := len(firewall.allowed) >= firewall.options.MaxFingerprints
+	firewall.mu.RUnlock()
+	if ok || full {
+		return
+	}
+
+	firewall.mu.Lock()
+	if len(firewall.allowed) < firewall.options.MaxFingerprints {
+		firewall.allowed[hash] = normalized
+	}
+	firewall.mu.Unlock()
+}
+
+// Load adds the fingerprints of a allowlist to the allowlist.
+// The fingerprints loaded are not bounded by MaxFingerprints.
+func (firewall *Firewall) Load(r io.Reader) error {
+	loaded := make(map[uint64]string)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.SplitN(text, " ", 2)
+		hash, err := strconv.ParseUint(fields[0], 16, 64)
+		if err != nil {
+			return fmt.Errorf("middledriver: invalid fingerprint at line %d of allowlist: %w", line, err)
+		}
+		normalized := ""
+		if len(fields) > 1 {
+			normalized = strings.TrimSpace(fields[1])
+		}
+		loaded[hash] = normalized
+	}
+	err := scanner.Err()
+	if err != nil {
+		return err
+	}
+
+	firewall.mu.Lock()
+	for hash, normalized := range loaded {
+		firewall.allowed[hash] = normalized
+	}
+	firewall.mu.Unlock()
+	return nil
+}
+
+// Save writes the allowlist in the format read by Load.
+func (firewall *Firewall) Save(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	for _, fp := range firewall.Allowlist() {
+		normalized := strings.NewReplacer("\r", " ", "\n", " ").Replace(fp.Normalized)
+		_, err := buf.WriteString(fp.String() + " " + normalized + "\n")
+		if err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
+
+// LoadFile adds the fingerprints of the allowlist file at path to the allowlist.
+func (firewall *Firewall) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return firewall.Load(file)
+}
+
+// SaveFile writes the allowlist to the file at path, replacing it at once.
+func (firewall *Firewall) SaveFile(path string) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	err = firewall.Save(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
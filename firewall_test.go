@@ -0,0 +1,185 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/wencan/middledriver/internal/fakedriver"
+	"github.com/wencan/middledriver/sqlparse"
+)
+
+func TestFirewall(t *testing.T) {
+	var rejected []string
+	firewall := NewFirewall(FirewallOptions{
+		OnReject: func(ctx context.Context, info OperationInfo) {
+			rejected = append(rejected, info.Query)
+		},
+	})
+	dri := Driver{
+		Target: fakedriver.FakeDriver{
+			ExpectedExecContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+				return fakedriver.FakeResult{}, nil
+			},
+		},
+		MiddlewareGroup: firewall.MiddlewareGroup(),
+	}
+	sql.Register("test_firewall", dri)
+
+	db, err := sql.Open("test_firewall", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.TODO()
+
+	_, err = db.ExecContext(ctx, "UPDATE users SET name = 'foo' WHERE id = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := db.PrepareContext(ctx, "DELETE FROM users WHERE id = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(firewall.Allowlist()); got != 2 {
+		t.Fatalf("want 2 fingerprints learned, got %d", got)
+	}
+
+	firewall.SetMode(FirewallEnforcing)
+	_, err = db.ExecContext(ctx, "UPDATE users SET name = 'bar' WHERE id = 2")
+	if err != nil {
+		t.Fatalf("want same fingerprint allowed, got %v", err)
+	}
+	_, err = stmt.ExecContext(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.ExecContext(ctx, "UPDATE users SET name = 'bar' WHERE id = 2 OR 1 = 1")
+	var firewallErr *FirewallError
+	if !errors.As(err, &firewallErr) {
+		t.Fatalf("want *FirewallError, got %v", err)
+	}
+	if want := []string{"UPDATE users SET name = 'bar' WHERE id = 2 OR 1 = 1"}; !reflect.DeepEqual(want, rejected) {
+		t.Fatalf("want rejected %+v, got %+v", want, rejected)
+	}
+	if got := len(firewall.Allowlist()); got != 2 {
+		t.Fatalf("want nothing learned while enforcing, got %d fingerprints", got)
+	}
+}
+
+func TestFirewall_ExecutableComment(t *testing.T) {
+	firewall := NewFirewall(FirewallOptions{Mode: FirewallEnforcing})
+	firewall.Allow("SELECT name FROM t WHERE id = 1", sqlparse.DialectMySQL)
+	dri := Driver{
+		Target: fakedriver.FakeDriver{
+			ExpectedQueryContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
+				return &fakedriver.FakeRows{ColumnNames: []string{"name"}}, nil
+			},
+		},
+		Dialect:         sqlparse.DialectMySQL,
+		MiddlewareGroup: firewall.MiddlewareGroup(),
+	}
+	sql.Register("test_firewall_executable_comment", dri)
+
+	db, err := sql.Open("test_firewall_executable_comment", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(context.TODO(), "SELECT name FROM t WHERE id = 2 /* by id */")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	for _, query := range []string{
+		"SELECT name FROM t WHERE id = 1 /*!50000 UNION SELECT password FROM users */",
+		"SELECT name FROM t WHERE id = 1 /*M! UNION SELECT password FROM users */",
+		"SELECT name FROM t WHERE id = 1 /*!*/",
+	} {
+		_, err = db.QueryContext(context.TODO(), query)
+		var firewallErr *FirewallError
+		if !errors.As(err, &firewallErr) {
+			t.Fatalf("want *FirewallError for %s, got %v", query, err)
+		}
+	}
+}
+
+func TestFirewall_BackslashQuote(t *testing.T) {
+	dir, paths := newSQLiteBackends(t, "firewall")
+	defer os.RemoveAll(dir)
+	target, err := DSNConnector(&sqlite3.SQLiteDriver{}, paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	firewall := NewFirewall(FirewallOptions{Mode: FirewallEnforcing})
+	firewall.Allow("SELECT * FROM backend WHERE name = 'bob'", sqlparse.DialectGeneric)
+	dri := Driver{
+		Target:          &sqlite3.SQLiteDriver{},
+		MiddlewareGroup: firewall.MiddlewareGroup(),
+	}
+	db := sql.OpenDB(dri.NewConnector(target))
+	defer db.Close()
+
+	// sqlite does not escape quotes with backslashes, the query returns every row
+	_, err = db.QueryContext(context.TODO(), "SELECT * FROM backend WHERE name = 'x\\' OR 1=1 --'")
+	var firewallErr *FirewallError
+	if !errors.As(err, &firewallErr) {
+		t.Fatalf("want *FirewallError, got %v", err)
+	}
+}
+
+func TestFirewallFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "middledriver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "allowlist")
+
+	learned := NewFirewall(FirewallOptions{})
+	learned.Allow("SELECT * FROM users WHERE id = 1", sqlparse.DialectGeneric)
+	learned.Allow("DELETE FROM users WHERE id IN (1, 2, 3)", sqlparse.DialectGeneric)
+	err = learned.SaveFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewFirewall(FirewallOptions{Mode: FirewallEnforcing})
+	err = loaded.LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(learned.Allowlist(), loaded.Allowlist()) {
+		t.Fatalf("want allowlist %+v, got %+v", learned.Allowlist(), loaded.Allowlist())
+	}
+
+	err = loaded.Load(strings.NewReader("# reviewed\n\nnot-a-hash select ?\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("want error at line 3, got %v", err)
+	}
+}
+
+func TestFirewallMaxFingerprints(t *testing.T) {
+	firewall := NewFirewall(FirewallOptions{MaxFingerprints: 1})
+	firewall.Allow("SELECT * FROM users", sqlparse.DialectGeneric)
+	firewall.Allow("SELECT * FROM orders", sqlparse.DialectGeneric)
+	if got := len(firewall.Allowlist()); got != 1 {
+		t.Fatalf("want 1 fingerprint, got %d", got)
+	}
+}
@@ -1,5 +1,5 @@
 module github.com/wencan/middledriver
 
-go 1.14
+go 1.15
 
 require github.com/mattn/go-sqlite3 v1.14.0
@@ -0,0 +1,46 @@
+// Package boundedcache provides the bounded caches of parsed queries.
+package boundedcache
+
+import "sync"
+
+// Cache is a map safe for concurrent use holding at most size values.
+// When it is full it is emptied, so keys such as queries with inlined literals cannot grow it without bound.
+type Cache struct {
+	size int
+
+	mutex  sync.RWMutex
+	values map[interface{}]interface{}
+}
+
+// New create a Cache holding at most size values.
+func New(size int) *Cache {
+	return &Cache{
+		size:   size,
+		values: make(map[interface{}]interface{}),
+	}
+}
+
+// Load returns the value stored for key.
+func (cache *Cache) Load(key interface{}) (interface{}, bool) {
+	cache.mutex.RLock()
+	value, ok := cache.values[key]
+	cache.mutex.RUnlock()
+	return value, ok
+}
+
+// Store stores value for key, emptying the cache first if it is full.
+func (cache *Cache) Store(key, value interface{}) {
+	cache.mutex.Lock()
+	if len(cache.values) >= cache.size {
+		cache.values = make(map[interface{}]interface{})
+	}
+	cache.values[key] = value
+	cache.mutex.Unlock()
+}
+
+// Len returns the number of values stored.
+func (cache *Cache) Len() int {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	return len(cache.values)
+}
@@ -0,0 +1,27 @@
+package boundedcache
+
+import "testing"
+
+func TestCache(t *testing.T) {
+	type key struct {
+		query string
+		style int
+	}
+	cache := New(2)
+	cache.Store(key{query: "SELECT 1"}, 1)
+	cache.Store(key{query: "SELECT 1", style: 1}, 2)
+
+	value, ok := cache.Load(key{query: "SELECT 1", style: 1})
+	if !ok || value != 2 {
+		t.Fatalf("want 2, got %v, %v", value, ok)
+	}
+
+	// the full cache is emptied
+	cache.Store(key{query: "SELECT 2"}, 3)
+	if got := cache.Len(); got != 1 {
+		t.Fatalf("want 1 value after emptied, got %d", got)
+	}
+	if _, ok := cache.Load(key{query: "SELECT 1"}); ok {
+		t.Fatal("want value dropped when emptied")
+	}
+}
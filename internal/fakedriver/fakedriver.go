@@ -20,6 +20,12 @@ type FakeDriver struct {
 	ExpectedQueryContext func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error)
 
 	ExpectedExecContext func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error)
+
+	ExpectedNumInput func(query string) int
+
+	// PrepareOnly makes the connections implement only Conn and ConnPrepareContext,
+	// queries and executes must be prepared and transactions begun by Begin.
+	PrepareOnly bool
 }
 
 // Open implements Driver.
@@ -40,10 +46,14 @@ type FakeConnector struct {
 
 // Connect implements Connector.
 func (connector FakeConnector) Connect(ctx context.Context) (driver.Conn, error) {
-	return FakeConn{
+	conn := FakeConn{
 		driver: connector.driver,
 		// connector: &connector,
-	}, nil
+	}
+	if connector.driver.PrepareOnly {
+		return FakePrepareOnlyConn{conn: conn}, nil
+	}
+	return conn, nil
 }
 
 // Driver implements Connector.
@@ -111,6 +121,31 @@ func (conn FakeConn) ExecContext(ctx context.Context, query string, namedArg []d
 	return nil, ErrUnimplemented
 }
 
+// FakePrepareOnlyConn is a FakeConn without QueryContext, ExecContext and BeginTx.
+type FakePrepareOnlyConn struct {
+	conn FakeConn
+}
+
+// Prepare implements Conn.
+func (conn FakePrepareOnlyConn) Prepare(query string) (driver.Stmt, error) {
+	return conn.conn.Prepare(query)
+}
+
+// PrepareContext implements ConnPrepareContext.
+func (conn FakePrepareOnlyConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return conn.conn.PrepareContext(ctx, query)
+}
+
+// Close implements Conn.
+func (conn FakePrepareOnlyConn) Close() error {
+	return conn.conn.Close()
+}
+
+// Begin implements Conn.
+func (conn FakePrepareOnlyConn) Begin() (driver.Tx, error) {
+	return FakeTx{}, nil
+}
+
 type FakeTx struct {
 }
 
@@ -134,6 +169,9 @@ func (stmt FakeStmt) Close() error {
 
 // NumInput implements Stmt.
 func (stmt FakeStmt) NumInput() int {
+	if stmt.driver.ExpectedNumInput != nil {
+		return stmt.driver.ExpectedNumInput(stmt.query)
+	}
 	return -1
 }
 
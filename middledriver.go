@@ -17,6 +17,12 @@ type StmtQueryContextFunc func(ctx context.Context, namedArg []driver.NamedValue
 // StmtExecContextFunc is a function that handle execute from statement.
 type StmtExecContextFunc func(ctx context.Context, namedArg []driver.NamedValue) (driver.Result, error)
 
+// PrepareContextFunc is a function that handle prepare from conntions.
+type PrepareContextFunc func(ctx context.Context, query string) (driver.Stmt, error)
+
+// BeginTxFunc is a function that handle begin of transactions from conntions.
+type BeginTxFunc func(ctx context.Context, opts driver.TxOptions) (driver.Tx, error)
+
 // QueryContextMiddleware is a function which receives an QueryContextFunc and returns another QueryContextFunc.
 type QueryContextMiddleware func(next QueryContextFunc) QueryContextFunc
 
@@ -29,6 +35,13 @@ type StmtQueryContextMiddleware func(next StmtQueryContextFunc) StmtQueryContext
 // StmtExecContextMiddleware is a function which receives an StmtExecContextFunc and returns another StmtExecContextFunc.
 type StmtExecContextMiddleware func(next StmtExecContextFunc) StmtExecContextFunc
 
+// PrepareContextMiddleware is a function which receives an PrepareContextFunc and returns another PrepareContextFunc.
+// The statement middlewares are created with the query before it is rewritten by PrepareContextMiddleware.
+type PrepareContextMiddleware func(next PrepareContextFunc) PrepareContextFunc
+
+// BeginTxMiddleware is a function which receives an BeginTxFunc and returns another BeginTxFunc.
+type BeginTxMiddleware func(next BeginTxFunc) BeginTxFunc
+
 // NewStmtQueryContextMiddleware create a StmtQueryContextMiddleware base on a query statement.
 type NewStmtQueryContextMiddleware func(query string) (StmtQueryContextMiddleware, error)
 
@@ -44,6 +57,10 @@ type MiddlewareGroup struct {
 	NewStmtExecContextMiddleware NewStmtExecContextMiddleware
 
 	NewStmtQueryContextMiddleware NewStmtQueryContextMiddleware
+
+	PrepareContextMiddleware PrepareContextMiddleware
+
+	BeginTxMiddleware BeginTxMiddleware
 }
 
 // QueryContextMiddlewareChain creates a single QueryContextMiddleware out of a chain of many QueryContextMiddlewares.
@@ -70,6 +87,30 @@ func ExecContextMiddlewareChain(middlewares ...ExecContextMiddleware) ExecContex
 	}
 }
 
+// PrepareContextMiddlewareChain creates a single PrepareContextMiddleware out of a chain of many PrepareContextMiddlewares.
+func PrepareContextMiddlewareChain(middlewares ...PrepareContextMiddleware) PrepareContextMiddleware {
+	return func(next PrepareContextFunc) PrepareContextFunc {
+		for idx := len(middlewares) - 1; idx >= 0; idx-- {
+			next = middlewares[idx](next)
+		}
+		return func(ctx context.Context, query string) (driver.Stmt, error) {
+			return next(ctx, query)
+		}
+	}
+}
+
+// BeginTxMiddlewareChain creates a single BeginTxMiddleware out of a chain of many BeginTxMiddlewares.
+func BeginTxMiddlewareChain(middlewares ...BeginTxMiddleware) BeginTxMiddleware {
+	return func(next BeginTxFunc) BeginTxFunc {
+		for idx := len(middlewares) - 1; idx >= 0; idx-- {
+			next = middlewares[idx](next)
+		}
+		return func(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+			return next(ctx, opts)
+		}
+	}
+}
+
 // NewStmtQueryContextMiddlewareChain creates a single NewStmtQueryContextMiddleware out of a chain of many NewStmtQueryContextMiddlewares.
 func NewStmtQueryContextMiddlewareChain(newMiddlewares ...NewStmtQueryContextMiddleware) NewStmtQueryContextMiddleware {
 	return func(query string) (StmtQueryContextMiddleware, error) {
@@ -113,3 +154,55 @@ func NewStmtExecContextMiddlewareChain(newMiddlewares ...NewStmtExecContextMiddl
 		}, nil
 	}
 }
+
+// MiddlewareGroupChain creates a single MiddlewareGroup out of a chain of many MiddlewareGroups.
+// The first group is the outermost, nil middlewares are skipped.
+func MiddlewareGroupChain(groups ...MiddlewareGroup) MiddlewareGroup {
+	var queryContextMiddlewares []QueryContextMiddleware
+	var execContextMiddlewares []ExecContextMiddleware
+	var newStmtQueryContextMiddlewares []NewStmtQueryContextMiddleware
+	var newStmtExecContextMiddlewares []NewStmtExecContextMiddleware
+	var prepareContextMiddlewares []PrepareContextMiddleware
+	var beginTxMiddlewares []BeginTxMiddleware
+	for _, group := range groups {
+		if group.QueryContextMiddleware != nil {
+			queryContextMiddlewares = append(queryContextMiddlewares, group.QueryContextMiddleware)
+		}
+		if group.ExecContextMiddleware != nil {
+			execContextMiddlewares = append(execContextMiddlewares, group.ExecContextMiddleware)
+		}
+		if group.NewStmtQueryContextMiddleware != nil {
+			newStmtQueryContextMiddlewares = append(newStmtQueryContextMiddlewares, group.NewStmtQueryContextMiddleware)
+		}
+		if group.NewStmtExecContextMiddleware != nil {
+			newStmtExecContextMiddlewares = append(newStmtExecContextMiddlewares, group.NewStmtExecContextMiddleware)
+		}
+		if group.PrepareContextMiddleware != nil {
+			prepareContextMiddlewares = append(prepareContextMiddlewares, group.PrepareContextMiddleware)
+		}
+		if group.BeginTxMiddleware != nil {
+			beginTxMiddlewares = append(beginTxMiddlewares, group.BeginTxMiddleware)
+		}
+	}
+
+	var chain MiddlewareGroup
+	if len(queryContextMiddlewares) > 0 {
+		chain.QueryContextMiddleware = QueryContextMiddlewareChain(queryContextMiddlewares...)
+	}
+	if len(execContextMiddlewares) > 0 {
+		chain.ExecContextMiddleware = ExecContextMiddlewareChain(execContextMiddlewares...)
+	}
+	if len(newStmtQueryContextMiddlewares) > 0 {
+		chain.NewStmtQueryContextMiddleware = NewStmtQueryContextMiddlewareChain(newStmtQueryContextMiddlewares...)
+	}
+	if len(newStmtExecContextMiddlewares) > 0 {
+		chain.NewStmtExecContextMiddleware = NewStmtExecContextMiddlewareChain(newStmtExecContextMiddlewares...)
+	}
+	if len(prepareContextMiddlewares) > 0 {
+		chain.PrepareContextMiddleware = PrepareContextMiddlewareChain(prepareContextMiddlewares...)
+	}
+	if len(beginTxMiddlewares) > 0 {
+		chain.BeginTxMiddleware = BeginTxMiddlewareChain(beginTxMiddlewares...)
+	}
+	return chain
+}
@@ -0,0 +1,98 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/wencan/middledriver/internal/fakedriver"
+	"github.com/wencan/middledriver/sqlparse"
+)
+
+func TestMiddlewareGroupChain(t *testing.T) {
+	var calls []string
+	record := func(name string) MiddlewareGroup {
+		return aroundMiddlewareGroup(func(ctx context.Context, op Operation, query string, namedArg []driver.NamedValue, next func(ctx context.Context) error) error {
+			calls = append(calls, name+":"+string(op))
+			return next(ctx)
+		})
+	}
+
+	group := MiddlewareGroupChain(record("first"), MiddlewareGroup{}, record("second"))
+	if group.QueryContextMiddleware == nil || group.ExecContextMiddleware == nil || group.NewStmtQueryContextMiddleware == nil || group.NewStmtExecContextMiddleware == nil {
+		t.Fatalf("want all middlewares, got %+v", group)
+	}
+
+	exec := group.ExecContextMiddleware(func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+		return nil, nil
+	})
+	exec(context.TODO(), "SELECT 1", nil)
+
+	stmtMiddleware, err := group.NewStmtQueryContextMiddleware("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmtQuery := stmtMiddleware(func(ctx context.Context, namedArg []driver.NamedValue) (driver.Rows, error) {
+		return nil, nil
+	})
+	stmtQuery(context.TODO(), nil)
+
+	want := []string{"first:exec", "second:exec", "first:stmt_query", "second:stmt_query"}
+	if !reflect.DeepEqual(want, calls) {
+		t.Fatalf("want calls %+v, got %+v", want, calls)
+	}
+}
+
+func TestOperationInfoFromContext(t *testing.T) {
+	var infos []OperationInfo
+	dri := Driver{
+		Target: fakedriver.FakeDriver{
+			ExpectedExecContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+				info, ok := OperationInfoFromContext(ctx)
+				if !ok {
+					return nil, errors.New("want operation info")
+				}
+				infos = append(infos, info)
+				return fakedriver.FakeResult{}, nil
+			},
+		},
+	}
+	sql.Register("test_operation_info", dri)
+
+	db, err := sql.Open("test_operation_info", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.ExecContext(context.TODO(), "DELETE FROM users WHERE id = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := db.PrepareContext(context.TODO(), "DELETE FROM users WHERE id = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(context.TODO(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []OperationInfo{
+		{Operation: OperationExec, Query: "DELETE FROM users WHERE id = 1"},
+		{Operation: OperationStmtExec, Query: "DELETE FROM users WHERE id = ?"},
+	}
+	if !reflect.DeepEqual(want, infos) {
+		t.Fatalf("want infos %+v, got %+v", want, infos)
+	}
+	if stmt := infos[0].Statement(); stmt.Type != sqlparse.StatementDelete || !reflect.DeepEqual(stmt.Tables, []string{"users"}) {
+		t.Fatalf("want delete from users, got %+v", stmt)
+	}
+	if infos[0].Fingerprint() != infos[1].Fingerprint() {
+		t.Fatalf("want same fingerprint, got %+v and %+v", infos[0].Fingerprint(), infos[1].Fingerprint())
+	}
+}
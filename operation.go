@@ -0,0 +1,128 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql/driver"
+
+	"github.com/wencan/middledriver/fingerprint"
+	"github.com/wencan/middledriver/sqlparse"
+)
+
+// Operation is the kind of a call passing through the middlewares.
+type Operation string
+
+const (
+	// OperationQuery is a query from connections.
+	OperationQuery Operation = "query"
+
+	// OperationExec is a execute from connections.
+	OperationExec Operation = "exec"
+
+	// OperationStmtQuery is a query from statements.
+	OperationStmtQuery Operation = "stmt_query"
+
+	// OperationStmtExec is a execute from statements.
+	OperationStmtExec Operation = "stmt_exec"
+
+	// OperationPrepare is a prepare from connections.
+	OperationPrepare Operation = "prepare"
+
+	// OperationBegin is a begin of transactions from connections.
+	OperationBegin Operation = "begin"
+)
+
+// OperationInfo describes the operation passing through the middlewares.
+type OperationInfo struct {
+	Operation Operation
+
+	// Query is the query before any middleware rewrote it.
+	Query string
+
+	// Dialect is the SQL dialect of the driver.
+	Dialect sqlparse.Dialect
+
+	// Endpoint is the name of the endpoint which the connection was established to, set by FailoverConnector.
+	Endpoint string
+
+	// InTx reports whether the connection is in a transaction.
+	InTx bool
+}
+
+// Fingerprint returns the fingerprint of the query following Dialect.
+func (info OperationInfo) Fingerprint() fingerprint.Fingerprint {
+	return fingerprint.OfDialect(info.Query, info.Dialect)
+}
+
+// Statement returns the classification of the query.
+func (info OperationInfo) Statement() sqlparse.Statement {
+	return sqlparse.Of(info.Query, info.Dialect)
+}
+
+type operationInfoKey struct{}
+
+func withOperationInfo(ctx context.Context, info OperationInfo) context.Context {
+	return context.WithValue(ctx, operationInfoKey{}, info)
+}
+
+// OperationInfoFromContext returns the OperationInfo of the operation which ctx was passed to.
+func OperationInfoFromContext(ctx context.Context) (OperationInfo, bool) {
+	info, ok := ctx.Value(operationInfoKey{}).(OperationInfo)
+	return info, ok
+}
+
+// aroundFunc is called around each query and execute, it returns the error of its last call of next.
+type aroundFunc func(ctx context.Context, op Operation, query string, namedArg []driver.NamedValue, next func(ctx context.Context) error) error
+
+// aroundMiddlewareGroup creates a MiddlewareGroup which calls around for every operation.
+func aroundMiddlewareGroup(around aroundFunc) MiddlewareGroup {
+	return MiddlewareGroup{
+		QueryContextMiddleware: func(next QueryContextFunc) QueryContextFunc {
+			return func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
+				var rows driver.Rows
+				err := around(ctx, OperationQuery, query, namedArg, func(ctx context.Context) error {
+					var err error
+					rows, err = next(ctx, query, namedArg)
+					return err
+				})
+				return rows, err
+			}
+		},
+		ExecContextMiddleware: func(next ExecContextFunc) ExecContextFunc {
+			return func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+				var result driver.Result
+				err := around(ctx, OperationExec, query, namedArg, func(ctx context.Context) error {
+					var err error
+					result, err = next(ctx, query, namedArg)
+					return err
+				})
+				return result, err
+			}
+		},
+		NewStmtQueryContextMiddleware: func(query string) (StmtQueryContextMiddleware, error) {
+			return func(next StmtQueryContextFunc) StmtQueryContextFunc {
+				return func(ctx context.Context, namedArg []driver.NamedValue) (driver.Rows, error) {
+					var rows driver.Rows
+					err := around(ctx, OperationStmtQuery, query, namedArg, func(ctx context.Context) error {
+						var err error
+						rows, err = next(ctx, namedArg)
+						return err
+					})
+					return rows, err
+				}
+			}, nil
+		},
+		NewStmtExecContextMiddleware: func(query string) (StmtExecContextMiddleware, error) {
+			return func(next StmtExecContextFunc) StmtExecContextFunc {
+				return func(ctx context.Context, namedArg []driver.NamedValue) (driver.Result, error) {
+					var result driver.Result
+					err := around(ctx, OperationStmtExec, query, namedArg, func(ctx context.Context) error {
+						var err error
+						result, err = next(ctx, namedArg)
+						return err
+					})
+					return result, err
+				}
+			}, nil
+		},
+	}
+}
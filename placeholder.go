@@ -0,0 +1,244 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+
+	"github.com/wencan/middledriver/internal/boundedcache"
+	"github.com/wencan/middledriver/sqlparse"
+)
+
+type rewriteKey struct {
+	query   string
+	dialect sqlparse.Dialect
+	style   sqlparse.PlaceholderStyle
+	named   bool
+}
+
+var rewriteCache = boundedcache.New(4096)
+
+// rewritePlaceholders is sqlparse.RewritePlaceholders with cache,
+// or sqlparse.RewriteNamedPlaceholders if named.
+func rewritePlaceholders(query string, dialect sqlparse.Dialect, style sqlparse.PlaceholderStyle, named bool) sqlparse.Rewritten {
+	key := rewriteKey{query: query, dialect: dialect, style: style, named: named}
+	if rewritten, ok := rewriteCache.Load(key); ok {
+		return rewritten.(sqlparse.Rewritten)
+	}
+
+	var rewritten sqlparse.Rewritten
+	if named {
+		rewritten = sqlparse.RewriteNamedPlaceholders(query, dialect, style)
+	} else {
+		rewritten = sqlparse.RewritePlaceholders(query, dialect, style)
+	}
+	rewriteCache.Store(key, rewritten)
+	return rewritten
+}
+
+// bindParams returns the arguments of params in order, found by name or by ordinal in namedArg.
+// Params mixing named and positional parameters are rejected, their ordinals are ambiguous,
+// as are arguments bound to no param.
+func bindParams(params []sqlparse.Param, namedArg []driver.NamedValue) ([]driver.NamedValue, error) {
+	var named, positional bool
+	for _, param := range params {
+		if param.Name != "" {
+			named = true
+		} else {
+			positional = true
+		}
+	}
+	if named && positional {
+		return nil, errors.New("mixed named and positional parameters")
+	}
+
+	args := make([]driver.NamedValue, len(params))
+	used := make([]bool, len(namedArg))
+	for idx, param := range params {
+		found := false
+		for argIdx, arg := range namedArg {
+			if param.Name != "" && arg.Name == param.Name || param.Name == "" && arg.Ordinal == param.Ordinal {
+				args[idx] = driver.NamedValue{Ordinal: idx + 1, Value: arg.Value}
+				used[argIdx] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			if param.Name != "" {
+				return nil, fmt.Errorf("missing argument for parameter %s", param.Name)
+			}
+			return nil, fmt.Errorf("missing argument for parameter %d", param.Ordinal)
+		}
+	}
+	for argIdx, arg := range namedArg {
+		if used[argIdx] {
+			continue
+		}
+		if arg.Name != "" {
+			return nil, fmt.Errorf("unused argument %s", arg.Name)
+		}
+		return nil, fmt.Errorf("unused argument %d", arg.Ordinal)
+	}
+	return args, nil
+}
+
+// namedParametersMiddlewareGroup creates the middlewares which rewrite :name and @name placeholders
+// into the positional style of dialect, and reorder the arguments to match.
+// Queries are tokenized following dialect, so MySQL user variables and SQL Server @p1 placeholders are kept.
+// Queries without named placeholders are passed unchanged.
+func namedParametersMiddlewareGroup(dialect sqlparse.Dialect) MiddlewareGroup {
+	rewrite := func(query string) sqlparse.Rewritten {
+		return rewritePlaceholders(query, dialect, dialect.PlaceholderStyle(), true)
+	}
+
+	return MiddlewareGroup{
+		QueryContextMiddleware: func(next QueryContextFunc) QueryContextFunc {
+			return func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
+				rewritten := rewrite(query)
+				if !rewritten.Named {
+					return next(ctx, query, namedArg)
+				}
+				args, err := bindParams(rewritten.Params, namedArg)
+				if err != nil {
+					return nil, err
+				}
+				return next(ctx, rewritten.Query, args)
+			}
+		},
+		ExecContextMiddleware: func(next ExecContextFunc) ExecContextFunc {
+			return func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+				rewritten := rewrite(query)
+				if !rewritten.Named {
+					return next(ctx, query, namedArg)
+				}
+				args, err := bindParams(rewritten.Params, namedArg)
+				if err != nil {
+					return nil, err
+				}
+				return next(ctx, rewritten.Query, args)
+			}
+		},
+		PrepareContextMiddleware: func(next PrepareContextFunc) PrepareContextFunc {
+			return func(ctx context.Context, query string) (driver.Stmt, error) {
+				rewritten := rewrite(query)
+				if !rewritten.Named {
+					return next(ctx, query)
+				}
+				return next(ctx, rewritten.Query)
+			}
+		},
+		NewStmtQueryContextMiddleware: func(query string) (StmtQueryContextMiddleware, error) {
+			rewritten := rewrite(query)
+			return func(next StmtQueryContextFunc) StmtQueryContextFunc {
+				if !rewritten.Named {
+					return next
+				}
+				return func(ctx context.Context, namedArg []driver.NamedValue) (driver.Rows, error) {
+					args, err := bindParams(rewritten.Params, namedArg)
+					if err != nil {
+						return nil, err
+					}
+					return next(ctx, args)
+				}
+			}, nil
+		},
+		NewStmtExecContextMiddleware: func(query string) (StmtExecContextMiddleware, error) {
+			rewritten := rewrite(query)
+			return func(next StmtExecContextFunc) StmtExecContextFunc {
+				if !rewritten.Named {
+					return next
+				}
+				return func(ctx context.Context, namedArg []driver.NamedValue) (driver.Result, error) {
+					args, err := bindParams(rewritten.Params, namedArg)
+					if err != nil {
+						return nil, err
+					}
+					return next(ctx, args)
+				}
+			}, nil
+		},
+	}
+}
+
+// PlaceholderMiddlewareGroup creates the middlewares which rewrite the placeholders of queries written
+// for the source dialect into the placeholder style of the driver's Dialect, and reorder the arguments to match.
+// String literals and comments are left untouched, rewrites are cached per query.
+func PlaceholderMiddlewareGroup(source sqlparse.Dialect) MiddlewareGroup {
+	rewrite := func(ctx context.Context, query string) (sqlparse.Rewritten, bool) {
+		info, ok := OperationInfoFromContext(ctx)
+		if !ok {
+			return sqlparse.Rewritten{}, false
+		}
+		return rewritePlaceholders(query, source, info.Dialect.PlaceholderStyle(), false), true
+	}
+
+	return MiddlewareGroup{
+		QueryContextMiddleware: func(next QueryContextFunc) QueryContextFunc {
+			return func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
+				rewritten, ok := rewrite(ctx, query)
+				if !ok {
+					return next(ctx, query, namedArg)
+				}
+				args, err := bindParams(rewritten.Params, namedArg)
+				if err != nil {
+					return nil, err
+				}
+				return next(ctx, rewritten.Query, args)
+			}
+		},
+		ExecContextMiddleware: func(next ExecContextFunc) ExecContextFunc {
+			return func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+				rewritten, ok := rewrite(ctx, query)
+				if !ok {
+					return next(ctx, query, namedArg)
+				}
+				args, err := bindParams(rewritten.Params, namedArg)
+				if err != nil {
+					return nil, err
+				}
+				return next(ctx, rewritten.Query, args)
+			}
+		},
+		PrepareContextMiddleware: func(next PrepareContextFunc) PrepareContextFunc {
+			return func(ctx context.Context, query string) (driver.Stmt, error) {
+				rewritten, ok := rewrite(ctx, query)
+				if !ok {
+					return next(ctx, query)
+				}
+				return next(ctx, rewritten.Query)
+			}
+		},
+		NewStmtQueryContextMiddleware: func(query string) (StmtQueryContextMiddleware, error) {
+			return func(next StmtQueryContextFunc) StmtQueryContextFunc {
+				return func(ctx context.Context, namedArg []driver.NamedValue) (driver.Rows, error) {
+					rewritten, ok := rewrite(ctx, query)
+					if !ok {
+						return next(ctx, namedArg)
+					}
+					args, err := bindParams(rewritten.Params, namedArg)
+					if err != nil {
+						return nil, err
+					}
+					return next(ctx, args)
+				}
+			}, nil
+		},
+		NewStmtExecContextMiddleware: func(query string) (StmtExecContextMiddleware, error) {
+			return func(next StmtExecContextFunc) StmtExecContextFunc {
+				return func(ctx context.Context, namedArg []driver.NamedValue) (driver.Result, error) {
+					rewritten, ok := rewrite(ctx, query)
+					if !ok {
+						return next(ctx, namedArg)
+					}
+					args, err := bindParams(rewritten.Params, namedArg)
+					if err != nil {
+						return nil, err
+					}
+					return next(ctx, args)
+				}
+			}, nil
+		},
+	}
+}
@@ -0,0 +1,324 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/wencan/middledriver/internal/fakedriver"
+	"github.com/wencan/middledriver/sqlparse"
+)
+
+func TestDriver_EmulateNamedParameters(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		DriverName   string
+		Dialect      sqlparse.Dialect
+		Prepare      bool
+		PrepareOnly  bool
+		Query        string
+		Args         []interface{}
+		WantQuery    string
+		WantNamedArg []driver.NamedValue
+		WantError    error
+	}{
+		{
+			Name:       "test_emulate_named_question",
+			DriverName: "test_emulate_named_question",
+			Dialect:    sqlparse.DialectMySQL,
+			Query:      "UPDATE users SET name = :name WHERE id = :id OR parent = :id",
+			Args:       []interface{}{sql.Named("id", 100), sql.Named("name", "Tom")},
+			WantQuery:  "UPDATE users SET name = ? WHERE id = ? OR parent = ?",
+			WantNamedArg: []driver.NamedValue{
+				{Ordinal: 1, Value: "Tom"},
+				{Ordinal: 2, Value: int64(100)},
+				{Ordinal: 3, Value: int64(100)},
+			},
+		},
+		{
+			Name:       "test_emulate_named_dollar_stmt",
+			DriverName: "test_emulate_named_dollar_stmt",
+			Dialect:    sqlparse.DialectPostgres,
+			Prepare:    true,
+			Query:      "UPDATE users SET name = :name WHERE id = :id OR parent = :id",
+			Args:       []interface{}{sql.Named("id", 100), sql.Named("name", "Tom")},
+			WantQuery:  "UPDATE users SET name = $1 WHERE id = $2 OR parent = $2",
+			WantNamedArg: []driver.NamedValue{
+				{Ordinal: 1, Value: "Tom"},
+				{Ordinal: 2, Value: int64(100)},
+			},
+		},
+		{
+			Name:       "test_emulate_named_positional",
+			DriverName: "test_emulate_named_positional",
+			Query:      "UPDATE users SET name = ? WHERE id = ?",
+			Args:       []interface{}{"Tom", 100},
+			WantQuery:  "UPDATE users SET name = ? WHERE id = ?",
+			WantNamedArg: []driver.NamedValue{
+				{Ordinal: 1, Value: "Tom"},
+				{Ordinal: 2, Value: int64(100)},
+			},
+		},
+		{
+			Name:       "test_emulate_named_sqlserver_native",
+			DriverName: "test_emulate_named_sqlserver_native",
+			Dialect:    sqlparse.DialectSQLServer,
+			Query:      "UPDATE users SET name = @p1 WHERE id = @p2",
+			Args:       []interface{}{"Tom", 100},
+			WantQuery:  "UPDATE users SET name = @p1 WHERE id = @p2",
+			WantNamedArg: []driver.NamedValue{
+				{Ordinal: 1, Value: "Tom"},
+				{Ordinal: 2, Value: int64(100)},
+			},
+		},
+		{
+			Name:       "test_emulate_named_mysql_variable",
+			DriverName: "test_emulate_named_mysql_variable",
+			Dialect:    sqlparse.DialectMySQL,
+			Query:      "UPDATE users SET rank = @rownum := @rownum + 1 WHERE id = ?",
+			Args:       []interface{}{100},
+			WantQuery:  "UPDATE users SET rank = @rownum := @rownum + 1 WHERE id = ?",
+			WantNamedArg: []driver.NamedValue{
+				{Ordinal: 1, Value: int64(100)},
+			},
+		},
+		{
+			Name:       "test_emulate_named_missing",
+			DriverName: "test_emulate_named_missing",
+			Query:      "UPDATE users SET name = :name WHERE id = :id",
+			Args:       []interface{}{sql.Named("id", 100)},
+			WantError:  fmt.Errorf("missing argument for parameter name"),
+		},
+		{
+			Name:       "test_emulate_named_unused",
+			DriverName: "test_emulate_named_unused",
+			Query:      "UPDATE users SET name = :name",
+			Args:       []interface{}{sql.Named("name", "Tom"), sql.Named("id", 100)},
+			WantError:  fmt.Errorf("unused argument id"),
+		},
+		{
+			Name:       "test_emulate_named_mixed",
+			DriverName: "test_emulate_named_mixed",
+			Query:      "UPDATE users SET name = :name WHERE id = ?",
+			Args:       []interface{}{sql.Named("name", "Tom"), 100},
+			WantError:  fmt.Errorf("mixed named and positional parameters"),
+		},
+		{
+			Name:        "test_emulate_named_prepare_only",
+			DriverName:  "test_emulate_named_prepare_only",
+			Dialect:     sqlparse.DialectPostgres,
+			PrepareOnly: true,
+			Query:       "UPDATE users SET name = :name WHERE id = :id OR parent = :id",
+			Args:        []interface{}{sql.Named("id", 100), sql.Named("name", "Tom")},
+			WantQuery:   "UPDATE users SET name = $1 WHERE id = $2 OR parent = $2",
+			WantNamedArg: []driver.NamedValue{
+				{Ordinal: 1, Value: "Tom"},
+				{Ordinal: 2, Value: int64(100)},
+			},
+		},
+		{
+			Name:        "test_emulate_named_prepare_only_stmt",
+			DriverName:  "test_emulate_named_prepare_only_stmt",
+			Dialect:     sqlparse.DialectMySQL,
+			Prepare:     true,
+			PrepareOnly: true,
+			Query:       "UPDATE users SET name = :name WHERE id = :id OR parent = :id",
+			Args:        []interface{}{sql.Named("id", 100), sql.Named("name", "Tom")},
+			WantQuery:   "UPDATE users SET name = ? WHERE id = ? OR parent = ?",
+			WantNamedArg: []driver.NamedValue{
+				{Ordinal: 1, Value: "Tom"},
+				{Ordinal: 2, Value: int64(100)},
+				{Ordinal: 3, Value: int64(100)},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			dri := Driver{
+				Target: fakedriver.FakeDriver{
+					ExpectedExecContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+						if query != testCase.WantQuery {
+							return nil, fmt.Errorf("want query %s, got %s", testCase.WantQuery, query)
+						}
+						if !reflect.DeepEqual(namedArg, testCase.WantNamedArg) {
+							return nil, fmt.Errorf("want namedArg %+v, got %+v", testCase.WantNamedArg, namedArg)
+						}
+						return fakedriver.FakeResult{AffectedRows: 1}, nil
+					},
+					PrepareOnly: testCase.PrepareOnly,
+				},
+				Dialect:                testCase.Dialect,
+				EmulateNamedParameters: true,
+			}
+			sql.Register(testCase.DriverName, dri)
+
+			db, err := sql.Open(testCase.DriverName, "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			if testCase.Prepare {
+				stmt, err := db.PrepareContext(context.TODO(), testCase.Query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer stmt.Close()
+				_, err = stmt.ExecContext(context.TODO(), testCase.Args...)
+			} else {
+				_, err = db.ExecContext(context.TODO(), testCase.Query, testCase.Args...)
+			}
+
+			if testCase.WantError != nil {
+				gotError := "<nil>"
+				if err != nil {
+					gotError = err.Error()
+				}
+				if testCase.WantError.Error() != gotError {
+					t.Fatalf("want error %s, got %s", testCase.WantError, gotError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestPlaceholderMiddlewareGroup(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		DriverName   string
+		Source       sqlparse.Dialect
+		Dialect      sqlparse.Dialect
+		Prepare      bool
+		PrepareOnly  bool
+		Query        string
+		Args         []interface{}
+		WantQuery    string
+		WantNamedArg []driver.NamedValue
+	}{
+		{
+			Name:       "test_placeholder_sqlite_to_postgres",
+			DriverName: "test_placeholder_sqlite_to_postgres",
+			Source:     sqlparse.DialectSQLite,
+			Dialect:    sqlparse.DialectPostgres,
+			Query:      "UPDATE users SET note = '?' WHERE id = ? AND age > ? -- ?",
+			Args:       []interface{}{100, 18},
+			WantQuery:  "UPDATE users SET note = '?' WHERE id = $1 AND age > $2 -- ?",
+			WantNamedArg: []driver.NamedValue{
+				{Ordinal: 1, Value: int64(100)},
+				{Ordinal: 2, Value: int64(18)},
+			},
+		},
+		{
+			Name:       "test_placeholder_postgres_to_sqlite_stmt",
+			DriverName: "test_placeholder_postgres_to_sqlite_stmt",
+			Source:     sqlparse.DialectPostgres,
+			Dialect:    sqlparse.DialectSQLite,
+			Prepare:    true,
+			Query:      "UPDATE users SET age = $2 WHERE id = $1 OR parent = $1",
+			Args:       []interface{}{100, 18},
+			WantQuery:  "UPDATE users SET age = ? WHERE id = ? OR parent = ?",
+			WantNamedArg: []driver.NamedValue{
+				{Ordinal: 1, Value: int64(18)},
+				{Ordinal: 2, Value: int64(100)},
+				{Ordinal: 3, Value: int64(100)},
+			},
+		},
+		{
+			Name:        "test_placeholder_postgres_to_sqlite_prepare_only",
+			DriverName:  "test_placeholder_postgres_to_sqlite_prepare_only",
+			Source:      sqlparse.DialectPostgres,
+			Dialect:     sqlparse.DialectSQLite,
+			PrepareOnly: true,
+			Query:       "UPDATE t SET a = $2 WHERE id = $1",
+			Args:        []interface{}{100, 18},
+			WantQuery:   "UPDATE t SET a = ? WHERE id = ?",
+			WantNamedArg: []driver.NamedValue{
+				{Ordinal: 1, Value: int64(18)},
+				{Ordinal: 2, Value: int64(100)},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			dri := Driver{
+				Target: fakedriver.FakeDriver{
+					ExpectedExecContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+						if query != testCase.WantQuery {
+							return nil, fmt.Errorf("want query %s, got %s", testCase.WantQuery, query)
+						}
+						if !reflect.DeepEqual(namedArg, testCase.WantNamedArg) {
+							return nil, fmt.Errorf("want namedArg %+v, got %+v", testCase.WantNamedArg, namedArg)
+						}
+						return fakedriver.FakeResult{AffectedRows: 1}, nil
+					},
+					PrepareOnly: testCase.PrepareOnly,
+				},
+				Dialect:         testCase.Dialect,
+				MiddlewareGroup: PlaceholderMiddlewareGroup(testCase.Source),
+			}
+			sql.Register(testCase.DriverName, dri)
+
+			db, err := sql.Open(testCase.DriverName, "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			if testCase.Prepare {
+				stmt, err := db.PrepareContext(context.TODO(), testCase.Query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer stmt.Close()
+				_, err = stmt.ExecContext(context.TODO(), testCase.Args...)
+			} else {
+				_, err = db.ExecContext(context.TODO(), testCase.Query, testCase.Args...)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestPlaceholderMiddlewareGroup_Ping(t *testing.T) {
+	dri := Driver{
+		Target: fakedriver.FakeDriver{
+			ExpectedQueryContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
+				if len(namedArg) != 0 {
+					return nil, fmt.Errorf("want no argument, got %+v", namedArg)
+				}
+				return &fakedriver.FakeRows{ColumnNames: []string{"1"}}, nil
+			},
+			PrepareOnly: true,
+		},
+		Dialect:         sqlparse.DialectPostgres,
+		MiddlewareGroup: PlaceholderMiddlewareGroup(sqlparse.DialectPostgres),
+	}
+	sql.Register("test_placeholder_ping", dri)
+
+	db, err := sql.Open("test_placeholder_ping", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	err = db.PingContext(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.ExecContext(context.TODO(), "UPDATE users SET age = $1", 18, 100)
+	if err == nil || err.Error() != "unused argument 2" {
+		t.Fatalf("want unused argument error, got %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+const (
+	// ProfileLabelOperation is the pprof label key of the operation.
+	ProfileLabelOperation = "sql_operation"
+
+	// ProfileLabelFingerprint is the pprof label key of the query fingerprint.
+	ProfileLabelFingerprint = "sql_fingerprint"
+)
+
+// ProfileMiddlewareGroup creates the middlewares which run every operation inside pprof.Do with
+// the operation and the query fingerprint as labels, and inside a runtime/trace region,
+// so CPU profiles and execution traces can be attributed to queries.
+// Traces log the normalized query, whose literals are replaced, never the query itself.
+func ProfileMiddlewareGroup() MiddlewareGroup {
+	return aroundMiddlewareGroup(func(ctx context.Context, op Operation, query string, namedArg []driver.NamedValue, next func(ctx context.Context) error) error {
+		info, _ := OperationInfoFromContext(ctx)
+		info.Query = originalQuery(ctx, query)
+		fp := info.Fingerprint()
+
+		var err error
+		pprof.Do(ctx, pprof.Labels(ProfileLabelOperation, string(op), ProfileLabelFingerprint, fp.String()), func(ctx context.Context) {
+			region := trace.StartRegion(ctx, "sql."+string(op))
+			defer region.End()
+			if trace.IsEnabled() {
+				trace.Log(ctx, ProfileLabelFingerprint, fp.String())
+				trace.Log(ctx, "sql_query", fp.Normalized)
+			}
+
+			err = next(ctx)
+		})
+		return err
+	})
+}
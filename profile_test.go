@@ -0,0 +1,60 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"runtime/pprof"
+	"testing"
+
+	"github.com/wencan/middledriver/fingerprint"
+	"github.com/wencan/middledriver/internal/fakedriver"
+)
+
+func TestProfileMiddlewareGroup(t *testing.T) {
+	checkLabels := func(ctx context.Context, op Operation, query string) error {
+		if got, _ := pprof.Label(ctx, ProfileLabelOperation); got != string(op) {
+			return fmt.Errorf("want operation label %s, got %s", op, got)
+		}
+		if got, _ := pprof.Label(ctx, ProfileLabelFingerprint); got != fingerprint.Of(query).String() {
+			return fmt.Errorf("want fingerprint label %s, got %s", fingerprint.Of(query).String(), got)
+		}
+		return nil
+	}
+
+	dri := Driver{
+		Target: fakedriver.FakeDriver{
+			ExpectedQueryContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
+				return &fakedriver.FakeRows{ColumnNames: []string{"1"}}, checkLabels(ctx, OperationQuery, query)
+			},
+			ExpectedExecContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+				return fakedriver.FakeResult{}, checkLabels(ctx, OperationStmtExec, query)
+			},
+		},
+		MiddlewareGroup: ProfileMiddlewareGroup(),
+	}
+	sql.Register("test_profile", dri)
+
+	db, err := sql.Open("test_profile", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(context.TODO(), "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	stmt, err := db.PrepareContext(context.TODO(), "UPDATE users SET age = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(context.TODO(), 18)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
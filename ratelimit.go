@@ -0,0 +1,199 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimitError is returned for the statements rejected by RateLimitMiddlewareGroup.
+type RateLimitError struct {
+	// Key is the key of the exhausted bucket, such as the tenant.
+	Key string
+
+	// RetryAfter is when the bucket has a token again.
+	RetryAfter time.Duration
+}
+
+// Error implements error.
+func (err *RateLimitError) Error() string {
+	return "middledriver: rate limit exceeded for " + err.Key + ", retry after " + err.RetryAfter.String()
+}
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx carrying the tenant of its statements.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant set by WithTenant.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok
+}
+
+// RateLimitOptions configures RateLimitMiddlewareGroup.
+type RateLimitOptions struct {
+	// Rate is the number of statements per second allowed for each key.
+	// Nothing is limited if Rate is not positive.
+	Rate float64
+
+	// Burst is the size of the bucket of each key, default Rate rounded up.
+	Burst int
+
+	// Key returns the key of the bucket of a statement, default the tenant of WithTenant.
+	// Statements without a key are not limited.
+	Key func(ctx context.Context) string
+
+	// Wait makes statements wait for a token until their context is done, instead of being rejected at once.
+	// A statement whose deadline comes before its token is rejected at once.
+	Wait bool
+
+	// IdleTimeout is how long a full bucket is kept unused before it is evicted, default 1m.
+	IdleTimeout time.Duration
+}
+
+// RateLimitMiddlewareGroup creates a MiddlewareGroup which limits the rate of queries and executes by key
+// with token buckets, statements beyond the limit are rejected with *RateLimitError.
+func RateLimitMiddlewareGroup(options RateLimitOptions) MiddlewareGroup {
+	if options.Rate <= 0 || math.IsNaN(options.Rate) {
+		return MiddlewareGroup{}
+	}
+	if options.Burst <= 0 {
+		options.Burst = int(math.Ceil(options.Rate))
+	}
+	if options.Burst <= 0 {
+		options.Burst = 1
+	}
+	if options.Key == nil {
+		options.Key = func(ctx context.Context) string {
+			tenant, _ := TenantFromContext(ctx)
+			return tenant
+		}
+	}
+	if options.IdleTimeout <= 0 {
+		options.IdleTimeout = time.Minute
+	}
+
+	limiter := &rateLimiter{
+		options:   options,
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+	return aroundMiddlewareGroup(func(ctx context.Context, op Operation, query string, namedArg []driver.NamedValue, next func(ctx context.Context) error) error {
+		err := limiter.take(ctx)
+		if err != nil {
+			return err
+		}
+		return next(ctx)
+	})
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	options RateLimitOptions
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// take takes a token of the bucket of ctx, waiting for it if allowed.
+func (limiter *rateLimiter) take(ctx context.Context) error {
+	key := limiter.options.Key(ctx)
+	if key == "" {
+		return nil
+	}
+
+	wait, err := limiter.reserve(ctx, key, time.Now())
+	if err != nil || wait == 0 {
+		return err
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		limiter.cancel(key)
+		return ctx.Err()
+	}
+}
+
+// reserve takes a token, possibly in advance, and returns how long to wait for it.
+func (limiter *rateLimiter) reserve(ctx context.Context, key string, now time.Time) (time.Duration, error) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	limiter.sweep(now)
+	bucket, ok := limiter.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{
+			tokens: float64(limiter.options.Burst),
+			last:   now,
+		}
+		limiter.buckets[key] = bucket
+	}
+	limiter.refill(bucket, now)
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0, nil
+	}
+
+	wait := time.Duration((1 - bucket.tokens) / limiter.options.Rate * float64(time.Second))
+	if !limiter.options.Wait {
+		return 0, &RateLimitError{Key: key, RetryAfter: wait}
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Sub(now) < wait {
+		return 0, &RateLimitError{Key: key, RetryAfter: wait}
+	}
+	bucket.tokens--
+	return wait, nil
+}
+
+// cancel gives back a token reserved in advance.
+func (limiter *rateLimiter) cancel(key string) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	bucket, ok := limiter.buckets[key]
+	if ok {
+		bucket.tokens++
+	}
+}
+
+func (limiter *rateLimiter) refill(bucket *tokenBucket, now time.Time) {
+	elapsed := now.Sub(bucket.last)
+	if elapsed <= 0 {
+		return
+	}
+	bucket.tokens = math.Min(float64(limiter.options.Burst), bucket.tokens+elapsed.Seconds()*limiter.options.Rate)
+	bucket.last = now
+}
+
+// sweep evicts the full buckets unused for IdleTimeout, a new bucket is the same as a full one.
+func (limiter *rateLimiter) sweep(now time.Time) {
+	if now.Sub(limiter.lastSweep) < limiter.options.IdleTimeout {
+		return
+	}
+	limiter.lastSweep = now
+
+	for key, bucket := range limiter.buckets {
+		if now.Sub(bucket.last) < limiter.options.IdleTimeout {
+			continue
+		}
+		limiter.refill(bucket, now)
+		if bucket.tokens >= float64(limiter.options.Burst) {
+			delete(limiter.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wencan/middledriver/internal/fakedriver"
+)
+
+func TestRateLimitMiddlewareGroup(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		driverName string
+		options    RateLimitOptions
+		prepared   bool
+	}{
+		{
+			Name:       "reject",
+			driverName: "test_rate_limit_reject",
+			options:    RateLimitOptions{Rate: 20, Burst: 2},
+		},
+		{
+			Name:       "prepared",
+			driverName: "test_rate_limit_prepared",
+			options:    RateLimitOptions{Rate: 20, Burst: 2},
+			prepared:   true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			dri := Driver{
+				Target: fakedriver.FakeDriver{
+					ExpectedExecContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+						return fakedriver.FakeResult{}, nil
+					},
+				},
+				MiddlewareGroup: RateLimitMiddlewareGroup(testCase.options),
+			}
+			sql.Register(testCase.driverName, dri)
+
+			db, err := sql.Open(testCase.driverName, "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			exec := func(ctx context.Context) error {
+				_, err := db.ExecContext(ctx, "SELECT 1")
+				return err
+			}
+			if testCase.prepared {
+				stmt, err := db.Prepare("SELECT 1")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer stmt.Close()
+				exec = func(ctx context.Context) error {
+					_, err := stmt.ExecContext(ctx)
+					return err
+				}
+			}
+
+			tenantA := WithTenant(context.TODO(), "a")
+			tenantB := WithTenant(context.TODO(), "b")
+			for idx := 0; idx < 2; idx++ {
+				if err := exec(tenantA); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err = exec(tenantA)
+			var rateErr *RateLimitError
+			if !errors.As(err, &rateErr) || rateErr.Key != "a" || rateErr.RetryAfter <= 0 {
+				t.Fatalf("want RateLimitError of a, got %v", err)
+			}
+
+			// buckets are per tenant, statements without tenant are not limited
+			if err := exec(tenantB); err != nil {
+				t.Fatal(err)
+			}
+			for idx := 0; idx < 5; idx++ {
+				if err := exec(context.TODO()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			time.Sleep(60 * time.Millisecond)
+			if err := exec(tenantA); err != nil {
+				t.Fatalf("want bucket refilled, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddlewareGroup_ZeroRate(t *testing.T) {
+	dri := Driver{
+		Target: fakedriver.FakeDriver{
+			ExpectedExecContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+				return fakedriver.FakeResult{}, nil
+			},
+		},
+		MiddlewareGroup: RateLimitMiddlewareGroup(RateLimitOptions{Burst: 1, Wait: true}),
+	}
+	sql.Register("test_rate_limit_zero_rate", dri)
+
+	db, err := sql.Open("test_rate_limit_zero_rate", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := WithTenant(context.TODO(), "a")
+	for idx := 0; idx < 3; idx++ {
+		if _, err := db.ExecContext(ctx, "SELECT 1"); err != nil {
+			t.Fatalf("want nothing limited without a rate, got %v", err)
+		}
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	limiter := &rateLimiter{
+		options:   RateLimitOptions{Rate: 50, Burst: 1, Wait: true, IdleTimeout: time.Hour, Key: func(ctx context.Context) string { return "a" }},
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+	ctx := context.TODO()
+
+	start := time.Now()
+	for idx := 0; idx < 3; idx++ {
+		if err := limiter.take(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Fatalf("want waiting for 2 tokens at 50/s, took %v", elapsed)
+	}
+
+	// the deadline comes before the token
+	deadlineCtx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	var rateErr *RateLimitError
+	if err := limiter.take(deadlineCtx); !errors.As(err, &rateErr) {
+		t.Fatalf("want RateLimitError, got %v", err)
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	limiter := &rateLimiter{
+		options:   RateLimitOptions{Rate: 1, Burst: 1, IdleTimeout: time.Minute},
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+	now := time.Now()
+	for _, key := range []string{"a", "b"} {
+		if _, err := limiter.reserve(context.TODO(), key, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	limiter.reserve(context.TODO(), "b", now.Add(90*time.Second))
+	limiter.reserve(context.TODO(), "c", now.Add(2*time.Minute))
+	if _, ok := limiter.buckets["a"]; ok {
+		t.Fatal("want idle bucket evicted")
+	}
+	if _, ok := limiter.buckets["b"]; !ok {
+		t.Fatal("want recent bucket kept")
+	}
+}
@@ -0,0 +1,129 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync/atomic"
+
+	"github.com/wencan/middledriver/sqlparse"
+)
+
+// ErrReadOnlyMode is returned for the statements rejected by a enabled ReadOnlyMode.
+var ErrReadOnlyMode = errors.New("middledriver: read-only mode")
+
+// ReadOnlyMode rejects writes while it is enabled, such as during maintenance windows.
+// It can be enabled and disabled at any time, by any goroutine.
+type ReadOnlyMode struct {
+	enabled int32
+}
+
+// NewReadOnlyMode create a ReadOnlyMode.
+func NewReadOnlyMode(enabled bool) *ReadOnlyMode {
+	mode := &ReadOnlyMode{}
+	if enabled {
+		mode.Enable()
+	}
+	return mode
+}
+
+// Enable starts rejecting writes.
+func (mode *ReadOnlyMode) Enable() {
+	atomic.StoreInt32(&mode.enabled, 1)
+}
+
+// Disable stops rejecting writes.
+func (mode *ReadOnlyMode) Disable() {
+	atomic.StoreInt32(&mode.enabled, 0)
+}
+
+// Enabled reports whether writes are rejected.
+func (mode *ReadOnlyMode) Enabled() bool {
+	return atomic.LoadInt32(&mode.enabled) == 1
+}
+
+// MiddlewareGroup returns the middlewares which, while the mode is enabled,
+// reject the queries and executes classified as writes with ErrReadOnlyMode
+// and begin all transactions read-only.
+// Targets without ConnBeginTx cannot begin read-only transactions, theirs are begun as usual.
+// Transactions begun before the mode is enabled keep their options, but their writes are rejected too.
+func (mode *ReadOnlyMode) MiddlewareGroup() MiddlewareGroup {
+	group := aroundMiddlewareGroup(func(ctx context.Context, op Operation, query string, namedArg []driver.NamedValue, next func(ctx context.Context) error) error {
+		if mode.Enabled() {
+			info, _ := OperationInfoFromContext(ctx)
+			info.Query = originalQuery(ctx, query)
+			if readOnlyRejects(info.Query, info.Dialect) {
+				return ErrReadOnlyMode
+			}
+		}
+		return next(ctx)
+	})
+	group.BeginTxMiddleware = func(next BeginTxFunc) BeginTxFunc {
+		return func(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+			if mode.Enabled() && !opts.ReadOnly {
+				opts.ReadOnly = true
+				ctx = context.WithValue(ctx, optionalReadOnlyKey{}, true)
+			}
+			return next(ctx, opts)
+		}
+	}
+	return group
+}
+
+// pragmaArguments reports whether a PRAGMA statement of query has a value or a argument,
+// such as PRAGMA journal_mode = WAL or PRAGMA journal_mode(WAL).
+func pragmaArguments(query string, dialect sqlparse.Dialect) bool {
+	start, pragma := true, false
+	for _, token := range sqlparse.Tokenize(query, dialect) {
+		if !token.Significant() {
+			continue
+		}
+		if token.Text == ";" {
+			start, pragma = true, false
+			continue
+		}
+		if start {
+			start, pragma = false, token.Keyword() == "PRAGMA"
+			continue
+		}
+		if pragma && (token.Text == "(" || token.Kind == sqlparse.TokenOperator && strings.Contains(token.Text, "=")) {
+			return true
+		}
+	}
+	return false
+}
+
+// optionalReadOnlyKey marks the ReadOnly of driver.TxOptions set by ReadOnlyMode,
+// which is dropped for the targets which cannot begin read-only transactions.
+type optionalReadOnlyKey struct{}
+
+// readOnlyRejects reports whether query may write.
+// Session and transaction control statements are allowed, unrecognized statements
+// and MySQL executable comments are rejected. Only the reading forms of PRAGMA,
+// without value nor argument, are allowed, PRAGMA user_version = 5 writes the database.
+// As the effects are known for the whole query only, a SELECT or SHOW mixed with session
+// or transaction control statements is rejected, as is a SELECT which is not read-only,
+// such as SELECT ... FOR UPDATE or SELECT ... INTO.
+func readOnlyRejects(query string, dialect sqlparse.Dialect) bool {
+	stmt := sqlparse.Of(query, dialect)
+	if len(stmt.Types) == 0 || stmt.Executable {
+		return true
+	}
+	for _, typ := range stmt.Types {
+		switch typ {
+		case sqlparse.StatementPragma:
+			if pragmaArguments(query, dialect) {
+				return true
+			}
+		case sqlparse.StatementTransaction, sqlparse.StatementSet:
+		case sqlparse.StatementSelect, sqlparse.StatementShow:
+			if !stmt.ReadOnly {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
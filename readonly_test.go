@@ -0,0 +1,176 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/wencan/middledriver/internal/fakedriver"
+	"github.com/wencan/middledriver/sqlparse"
+)
+
+func TestReadOnlyRejects(t *testing.T) {
+	testCases := []struct {
+		query string
+		want  bool
+	}{
+		{query: "SELECT * FROM users", want: false},
+		{query: "EXPLAIN SELECT * FROM users", want: false},
+		{query: "SET search_path TO app", want: false},
+		{query: "BEGIN", want: false},
+		{query: "INSERT INTO users (name) VALUES ('foo')", want: true},
+		{query: "UPDATE users SET name = 'foo'", want: true},
+		{query: "DELETE FROM users", want: true},
+		{query: "CREATE TABLE users (id INT)", want: true},
+		{query: "SELECT * FROM users FOR UPDATE", want: true},
+		{query: "WITH d AS (DELETE FROM users RETURNING *) SELECT * FROM d", want: true},
+		{query: "EXPLAIN ANALYZE DELETE FROM users", want: true},
+		{query: "SELECT 1; DROP TABLE users", want: true},
+		{query: "CALL refresh()", want: true},
+		{query: "", want: true},
+	}
+	for _, testCase := range testCases {
+		if got := readOnlyRejects(testCase.query, sqlparse.DialectPostgres); got != testCase.want {
+			t.Fatalf("want readOnlyRejects(%q) %v, got %v", testCase.query, testCase.want, got)
+		}
+	}
+
+	mysqlTestCases := []struct {
+		query string
+		want  bool
+	}{
+		{query: "SELECT * FROM t /*!50000 INTO OUTFILE '/tmp/x' */", want: true},
+		{query: "SELECT * FROM t /*! FOR UPDATE */", want: true},
+		{query: "SELECT * FROM t /*M! WHERE 1 */", want: true},
+		{query: "SELECT /*+ MAX_EXECUTION_TIME(1000) */ * FROM t", want: false},
+	}
+	for _, testCase := range mysqlTestCases {
+		if got := readOnlyRejects(testCase.query, sqlparse.DialectMySQL); got != testCase.want {
+			t.Fatalf("want readOnlyRejects(%q) %v, got %v", testCase.query, testCase.want, got)
+		}
+	}
+
+	sqliteTestCases := []struct {
+		query string
+		want  bool
+	}{
+		{query: "PRAGMA user_version", want: false},
+		{query: "PRAGMA main.journal_mode", want: false},
+		{query: "PRAGMA user_version = 5", want: true},
+		{query: "PRAGMA journal_mode = DELETE", want: true},
+		{query: "PRAGMA writable_schema = 1", want: true},
+		{query: "PRAGMA journal_mode(WAL)", want: true},
+		{query: "PRAGMA user_version; PRAGMA user_version = 5", want: true},
+	}
+	for _, testCase := range sqliteTestCases {
+		if got := readOnlyRejects(testCase.query, sqlparse.DialectSQLite); got != testCase.want {
+			t.Fatalf("want readOnlyRejects(%q) %v, got %v", testCase.query, testCase.want, got)
+		}
+	}
+}
+
+func TestReadOnlyMode(t *testing.T) {
+	var executes int
+	var txOptions []driver.TxOptions
+	mode := NewReadOnlyMode(false)
+	dri := Driver{
+		Target: fakedriver.FakeDriver{
+			ExpectedExecContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+				executes++
+				return fakedriver.FakeResult{}, nil
+			},
+		},
+		MiddlewareGroup: MiddlewareGroupChain(mode.MiddlewareGroup(), MiddlewareGroup{
+			BeginTxMiddleware: func(next BeginTxFunc) BeginTxFunc {
+				return func(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+					txOptions = append(txOptions, opts)
+					return next(ctx, opts)
+				}
+			},
+		}),
+	}
+	sql.Register("test_read_only", dri)
+
+	db, err := sql.Open("test_read_only", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.TODO()
+
+	stmt, err := db.PrepareContext(ctx, "DELETE FROM users WHERE id = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	begin := func() {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx.Rollback()
+	}
+
+	_, err = db.ExecContext(ctx, "DELETE FROM users WHERE id = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	begin()
+
+	mode.Enable()
+	_, err = db.ExecContext(ctx, "DELETE FROM users WHERE id = 1")
+	if err != ErrReadOnlyMode {
+		t.Fatalf("want ErrReadOnlyMode, got %v", err)
+	}
+	_, err = stmt.ExecContext(ctx, 1)
+	if err != ErrReadOnlyMode {
+		t.Fatalf("want ErrReadOnlyMode from statement, got %v", err)
+	}
+	_, err = db.ExecContext(ctx, "SET search_path TO app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	begin()
+
+	mode.Disable()
+	_, err = stmt.ExecContext(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	begin()
+
+	if executes != 3 {
+		t.Fatalf("want 3 executes, got %d", executes)
+	}
+	if len(txOptions) != 3 || txOptions[0].ReadOnly || !txOptions[1].ReadOnly || txOptions[2].ReadOnly {
+		t.Fatalf("want only the transaction begun in read-only mode read-only, got %+v", txOptions)
+	}
+}
+
+func TestReadOnlyMode_BeginWithoutBeginTx(t *testing.T) {
+	mode := NewReadOnlyMode(true)
+	dri := Driver{
+		Target:          fakedriver.FakeDriver{PrepareOnly: true},
+		MiddlewareGroup: mode.MiddlewareGroup(),
+	}
+	sql.Register("test_read_only_begin", dri)
+
+	db, err := sql.Open("test_read_only_begin", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		t.Fatalf("want transaction begun without read-only option, got %v", err)
+	}
+	tx.Rollback()
+
+	_, err = db.BeginTx(context.TODO(), &sql.TxOptions{ReadOnly: true})
+	if err == nil {
+		t.Fatal("want error for a read-only transaction asked by the application, got nil")
+	}
+}
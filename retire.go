@@ -0,0 +1,103 @@
+package middledriver
+
+import (
+	"database/sql/driver"
+	"sync/atomic"
+	"time"
+)
+
+// ConnUsage is the usage of a connection.
+type ConnUsage struct {
+	// Created is when the connection was established.
+	Created time.Time
+
+	// Operations is the number of queries and executes.
+	Operations int64
+
+	// Errors is the number of failed queries and executes.
+	Errors int64
+
+	// Endpoint is the endpoint which the connection was established to, see OperationInfo.Endpoint.
+	Endpoint string
+}
+
+// RetirePolicy retires connections through driver.Validator, database/sql discards them instead of returning them to the pool.
+// It can recycle connections which hit known driver bugs.
+type RetirePolicy struct {
+	// MaxLifetime retires connections older than it, zero is unlimited.
+	MaxLifetime time.Duration
+
+	// MaxOperations retires connections after the number of queries and executes, zero is unlimited.
+	MaxOperations int64
+
+	// MaxErrors retires connections after the number of failed queries and executes, zero is unlimited.
+	MaxErrors int64
+
+	// Valid retires connections for which it returns false, optional.
+	Valid func(usage ConnUsage) bool
+}
+
+func (policy *RetirePolicy) valid(usage ConnUsage) bool {
+	if policy.MaxLifetime > 0 && time.Since(usage.Created) >= policy.MaxLifetime {
+		return false
+	}
+	if policy.MaxOperations > 0 && usage.Operations >= policy.MaxOperations {
+		return false
+	}
+	if policy.MaxErrors > 0 && usage.Errors >= policy.MaxErrors {
+		return false
+	}
+	if policy.Valid != nil {
+		return policy.Valid(usage)
+	}
+	return true
+}
+
+// connUsage counts the usage of a connection.
+type connUsage struct {
+	created    time.Time
+	operations int64
+	errors     int64
+}
+
+func newConnUsage() *connUsage {
+	return &connUsage{
+		created: time.Now(),
+	}
+}
+
+func (usage *connUsage) add(err error) {
+	if usage == nil {
+		return
+	}
+	atomic.AddInt64(&usage.operations, 1)
+	if err != nil && err != driver.ErrSkip {
+		atomic.AddInt64(&usage.errors, 1)
+	}
+}
+
+// Usage returns the usage of the connection, counted only if the driver has a RetirePolicy.
+func (conn Conn) Usage() ConnUsage {
+	usage := ConnUsage{
+		Endpoint: conn.endpoint,
+	}
+	if conn.usage != nil {
+		usage.Created = conn.usage.created
+		usage.Operations = atomic.LoadInt64(&conn.usage.operations)
+		usage.Errors = atomic.LoadInt64(&conn.usage.errors)
+	}
+	return usage
+}
+
+// IsValid implements Validator.
+func (conn Conn) IsValid() bool {
+	validator, ok := conn.target.(driver.Validator)
+	if ok && !validator.IsValid() {
+		return false
+	}
+
+	if conn.driver.RetirePolicy == nil {
+		return true
+	}
+	return conn.driver.RetirePolicy.valid(conn.Usage())
+}
@@ -0,0 +1,116 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/wencan/middledriver/internal/fakedriver"
+)
+
+func TestRetirePolicy(t *testing.T) {
+	dir, paths := newSQLiteBackends(t, "retire")
+	defer os.RemoveAll(dir)
+	target, err := DSNConnector(&sqlite3.SQLiteDriver{}, paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Name      string
+		policy    *RetirePolicy
+		queries   []string
+		wantConns int64
+	}{
+		{
+			Name:      "unlimited",
+			policy:    &RetirePolicy{},
+			queries:   []string{"SELECT 1", "SELECT 1", "SELECT 1", "SELECT 1"},
+			wantConns: 1,
+		},
+		{
+			Name:      "max operations",
+			policy:    &RetirePolicy{MaxOperations: 2},
+			queries:   []string{"SELECT 1", "SELECT 1", "SELECT 1", "SELECT 1", "SELECT 1"},
+			wantConns: 3,
+		},
+		{
+			Name:      "max errors",
+			policy:    &RetirePolicy{MaxErrors: 2},
+			queries:   []string{"SELECT 1", "SELECT * FROM missing", "SELECT 1", "SELECT * FROM missing", "SELECT 1"},
+			wantConns: 2,
+		},
+		{
+			Name:      "max lifetime",
+			policy:    &RetirePolicy{MaxLifetime: time.Nanosecond},
+			queries:   []string{"SELECT 1", "SELECT 1"},
+			wantConns: 2,
+		},
+		{
+			Name: "predicate",
+			policy: &RetirePolicy{Valid: func(usage ConnUsage) bool {
+				return usage.Operations < 3
+			}},
+			queries:   []string{"SELECT 1", "SELECT 1", "SELECT 1", "SELECT 1"},
+			wantConns: 2,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			dri := Driver{
+				Target:       &sqlite3.SQLiteDriver{},
+				RetirePolicy: testCase.policy,
+				Stats:        NewStats(),
+			}
+			db := sql.OpenDB(dri.NewConnector(target))
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+			ctx := context.TODO()
+
+			for _, query := range testCase.queries {
+				db.ExecContext(ctx, query)
+			}
+			if conns := dri.Stats.Snapshot().TotalConns; conns != testCase.wantConns {
+				t.Fatalf("want %d connections, got %d", testCase.wantConns, conns)
+			}
+		})
+	}
+}
+
+// invalidConn is a connection which reports itself invalid.
+type invalidConn struct {
+	fakedriver.FakeConn
+}
+
+func (conn invalidConn) IsValid() bool {
+	return false
+}
+
+func TestIsValidForwarded(t *testing.T) {
+	valid := fakedriver.FakeConn{}
+	invalid := invalidConn{}
+	testCases := []struct {
+		Name string
+		conn driver.Validator
+	}{
+		{Name: "router primary", conn: &routerConn{primary: invalid}},
+		{Name: "router replica", conn: &routerConn{primary: valid, replica: invalid}},
+		{Name: "shard", conn: &shardConn{backends: map[string]driver.Conn{"a": valid, "b": invalid}}},
+		{Name: "failover", conn: &failoverConn{Conn: invalid}},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			if testCase.conn.IsValid() {
+				t.Fatal("want invalid backend reported")
+			}
+		})
+	}
+
+	if !(&routerConn{primary: valid}).IsValid() {
+		t.Fatal("want valid router connection without replica")
+	}
+}
@@ -0,0 +1,340 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/wencan/middledriver/sqlparse"
+)
+
+// Backoff computes the exponential delays between attempts.
+type Backoff struct {
+	// Initial is the delay after the first attempt, default 100ms.
+	Initial time.Duration
+
+	// Max caps the delay, default 5s.
+	Max time.Duration
+
+	// Multiplier grows the delay after each attempt, default 2.
+	Multiplier float64
+
+	// Jitter randomizes each delay by up to the fraction, between 0 and 1.
+	Jitter float64
+}
+
+// Duration returns the delay after the attempt, attempts start at 1.
+func (backoff Backoff) Duration(attempt int) time.Duration {
+	initial := backoff.Initial
+	if initial <= 0 {
+		initial = 100 * time.Millisecond
+	}
+	max := backoff.Max
+	if max <= 0 {
+		max = 5 * time.Second
+	}
+	multiplier := backoff.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	delay := float64(initial)
+	for idx := 1; idx < attempt && delay < float64(max); idx++ {
+		delay *= multiplier
+	}
+	if delay > float64(max) {
+		delay = float64(max)
+	}
+	if backoff.Jitter > 0 {
+		delay += delay * backoff.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
+
+// wait sleeps for delay unless ctx is done first.
+func wait(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// IsTransientConnectError reports whether err is a network error or a bad connection, which may be over on a retry.
+// The errors of a done context are not transient, although context.DeadlineExceeded is a net.Error.
+func IsTransientConnectError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if err == driver.ErrBadConn || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// ConnectAttempt describes a attempt of connecting.
+type ConnectAttempt struct {
+	// Attempt is the number of the attempt, starting at 1.
+	Attempt int
+
+	Err error
+
+	// Duration is the time taken by the attempt.
+	Duration time.Duration
+
+	// Backoff is the delay before the next attempt, zero if no more attempt will be made.
+	Backoff time.Duration
+}
+
+// ConnectRetryPolicy retries connecting to the target.
+type ConnectRetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one, default 3.
+	MaxAttempts int
+
+	Backoff Backoff
+
+	// Retryable reports whether a error is worth a retry, default IsTransientConnectError.
+	Retryable func(err error) bool
+
+	// OnAttempt is called after every attempt, optional.
+	OnAttempt func(ctx context.Context, attempt ConnectAttempt)
+}
+
+// connect calls connect until it succeeds, a error is not retryable, the attempts run out or ctx is done.
+// A nil policy makes a single attempt.
+func (policy *ConnectRetryPolicy) connect(ctx context.Context, connect func(ctx context.Context) (driver.Conn, error)) (driver.Conn, error) {
+	if policy == nil {
+		return connect(ctx)
+	}
+
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = IsTransientConnectError
+	}
+
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		conn, err := connect(ctx)
+
+		retry := err != nil && attempt < maxAttempts && retryable(err)
+		var backoff time.Duration
+		if retry {
+			backoff = policy.Backoff.Duration(attempt)
+			// no time left for the next attempt
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+				retry = false
+				backoff = 0
+			}
+		}
+		if policy.OnAttempt != nil {
+			policy.OnAttempt(ctx, ConnectAttempt{
+				Attempt:  attempt,
+				Err:      err,
+				Duration: time.Since(start),
+				Backoff:  backoff,
+			})
+		}
+		if !retry {
+			return conn, err
+		}
+
+		if wait(ctx, backoff) != nil {
+			// the error of the last attempt tells more than the context
+			return nil, err
+		}
+	}
+}
+
+type idempotentKey struct{}
+
+// WithIdempotent returns a copy of ctx which marks its statements safe to retry, even if they write.
+func WithIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+// IsIdempotent reports whether ctx was marked by WithIdempotent.
+func IsIdempotent(ctx context.Context) bool {
+	idempotent, _ := ctx.Value(idempotentKey{}).(bool)
+	return idempotent
+}
+
+// IsTransientError reports whether err may be over on a retry:
+// a transient connect error, a busy or locked SQLite database by its result code,
+// or a serialization failure or deadlock by SQLSTATE.
+// The errors of a done context are not transient.
+func IsTransientError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if IsTransientConnectError(err) {
+		return true
+	}
+
+	var sqlState interface {
+		SQLState() string
+	}
+	if errors.As(err, &sqlState) {
+		switch sqlState.SQLState() {
+		case "40001", "40P01":
+			return true
+		}
+	}
+
+	code, ok := sqliteResultCode(err)
+	return ok && (code == sqliteBusy || code == sqliteLocked)
+}
+
+// the primary result codes of SQLite
+const (
+	sqliteBusy   = 5
+	sqliteLocked = 6
+)
+
+// sqliteResultCode returns the primary result code of a error of github.com/mattn/go-sqlite3 or modernc.org/sqlite,
+// without depending on them.
+func sqliteResultCode(err error) (int, bool) {
+	var coder interface {
+		Code() int
+	}
+	if errors.As(err, &coder) {
+		return coder.Code() & 0xff, true
+	}
+
+	for ; err != nil; err = errors.Unwrap(err) {
+		value := reflect.ValueOf(err)
+		if value.Kind() == reflect.Ptr && !value.IsNil() {
+			value = value.Elem()
+		}
+		if value.Type().PkgPath() != "github.com/mattn/go-sqlite3" {
+			continue
+		}
+		switch value.Type().Name() {
+		case "Error":
+			return int(value.FieldByName("Code").Int()), true
+		case "ErrNo":
+			return int(value.Int()), true
+		}
+	}
+	return 0, false
+}
+
+// RetryOptions configures RetryMiddlewareGroup.
+type RetryOptions struct {
+	// MaxAttempts is the number of attempts including the first one, default 3.
+	MaxAttempts int
+
+	Backoff Backoff
+
+	// Retryable reports whether a error is worth a retry, default IsTransientError.
+	Retryable func(err error) bool
+
+	// BudgetRatio limits the retries to the fraction of the calls, default 0.1.
+	BudgetRatio float64
+
+	// BudgetReserve is the number of retries allowed before BudgetRatio applies, default 10.
+	BudgetReserve int
+}
+
+// retryBudget is a token bucket filled by calls and drained by retries, in thousandths of a retry.
+type retryBudget struct {
+	ratio   int64
+	reserve int64
+	balance int64
+}
+
+func (budget *retryBudget) deposit() {
+	for {
+		balance := atomic.LoadInt64(&budget.balance)
+		next := balance + budget.ratio
+		if next > budget.reserve {
+			next = budget.reserve
+		}
+		if atomic.CompareAndSwapInt64(&budget.balance, balance, next) {
+			return
+		}
+	}
+}
+
+func (budget *retryBudget) withdraw() bool {
+	for {
+		balance := atomic.LoadInt64(&budget.balance)
+		if balance < 1000 {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&budget.balance, balance, balance-1000) {
+			return true
+		}
+	}
+}
+
+// RetryMiddlewareGroup creates a MiddlewareGroup which retries queries and executes failing with transient errors.
+// Only statements which are safe to retry are retried: read-only ones, or ones whose context is marked by WithIdempotent.
+// Statements in transactions are not retried, since the transaction may be aborted.
+// driver.ErrBadConn is returned without retrying, database/sql retries it on another connection.
+func RetryMiddlewareGroup(options RetryOptions) MiddlewareGroup {
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 3
+	}
+	if options.Retryable == nil {
+		options.Retryable = IsTransientError
+	}
+	if options.BudgetRatio <= 0 {
+		options.BudgetRatio = 0.1
+	}
+	if options.BudgetReserve <= 0 {
+		options.BudgetReserve = 10
+	}
+	budget := &retryBudget{
+		ratio:   int64(options.BudgetRatio * 1000),
+		reserve: int64(options.BudgetReserve) * 1000,
+		balance: int64(options.BudgetReserve) * 1000,
+	}
+
+	return aroundMiddlewareGroup(func(ctx context.Context, op Operation, query string, namedArg []driver.NamedValue, next func(ctx context.Context) error) error {
+		budget.deposit()
+
+		err := next(ctx)
+		if err == nil || !retrySafe(ctx, query) {
+			return err
+		}
+		for attempt := 1; attempt < options.MaxAttempts; attempt++ {
+			if err == driver.ErrBadConn || !options.Retryable(err) || !budget.withdraw() {
+				return err
+			}
+			if wait(ctx, options.Backoff.Duration(attempt)) != nil {
+				return err
+			}
+			err = next(ctx)
+			if err == nil {
+				return nil
+			}
+		}
+		return err
+	})
+}
+
+// retrySafe reports whether the statement can be executed again.
+func retrySafe(ctx context.Context, query string) bool {
+	info, ok := OperationInfoFromContext(ctx)
+	if ok && info.InTx {
+		return false
+	}
+	if IsIdempotent(ctx) {
+		return true
+	}
+	return sqlparse.Of(originalQuery(ctx, query), info.Dialect).ReadOnly
+}
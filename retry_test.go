@@ -0,0 +1,268 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/wencan/middledriver/internal/fakedriver"
+)
+
+// flakyConnector fails with err before connecting to target.
+type flakyConnector struct {
+	target driver.Connector
+
+	err      error
+	failures int
+	calls    int
+}
+
+func (connector *flakyConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	connector.calls++
+	if connector.calls <= connector.failures {
+		return nil, connector.err
+	}
+	return connector.target.Connect(ctx)
+}
+
+func (connector *flakyConnector) Driver() driver.Driver {
+	return connector.target.Driver()
+}
+
+func TestBackoff(t *testing.T) {
+	backoff := Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	for attempt, want := range []time.Duration{0, 10, 20, 40, 50, 50} {
+		if attempt == 0 {
+			continue
+		}
+		if got := backoff.Duration(attempt); got != want*time.Millisecond {
+			t.Fatalf("want backoff %v after attempt %d, got %v", want*time.Millisecond, attempt, got)
+		}
+	}
+
+	backoff.Jitter = 0.5
+	for idx := 0; idx < 100; idx++ {
+		got := backoff.Duration(2)
+		if got < 10*time.Millisecond || got > 30*time.Millisecond {
+			t.Fatalf("want jittered backoff in [10ms, 30ms], got %v", got)
+		}
+	}
+}
+
+func TestConnectRetry(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	target, _ := fakedriver.FakeDriver{}.OpenConnector("")
+
+	testCases := []struct {
+		Name     string
+		err      error
+		failures int
+		ctx      func() (context.Context, context.CancelFunc)
+		wantErr  bool
+		wantCall int
+	}{
+		{
+			Name:     "transient",
+			err:      refused,
+			failures: 2,
+			wantCall: 3,
+		},
+		{
+			Name:     "exhausted",
+			err:      refused,
+			failures: 5,
+			wantErr:  true,
+			wantCall: 3,
+		},
+		{
+			Name:     "not retryable",
+			err:      errors.New("access denied"),
+			failures: 1,
+			wantErr:  true,
+			wantCall: 1,
+		},
+		{
+			Name:     "deadline",
+			err:      refused,
+			failures: 2,
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.TODO(), 5*time.Millisecond)
+			},
+			wantErr:  true,
+			wantCall: 1,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			flaky := &flakyConnector{target: target, err: testCase.err, failures: testCase.failures}
+			var attempts []ConnectAttempt
+			dri := Driver{
+				Target: fakedriver.FakeDriver{},
+				ConnectRetry: &ConnectRetryPolicy{
+					Backoff: Backoff{Initial: 10 * time.Millisecond},
+					OnAttempt: func(ctx context.Context, attempt ConnectAttempt) {
+						attempts = append(attempts, attempt)
+					},
+				},
+			}
+
+			ctx, cancel := context.TODO(), context.CancelFunc(func() {})
+			if testCase.ctx != nil {
+				ctx, cancel = testCase.ctx()
+			}
+			defer cancel()
+
+			conn, err := dri.NewConnector(flaky).Connect(ctx)
+			if (err != nil) != testCase.wantErr {
+				t.Fatalf("want error %v, got %v", testCase.wantErr, err)
+			}
+			if err == nil {
+				conn.Close()
+			} else if err != testCase.err {
+				t.Fatalf("want error of the last attempt, got %v", err)
+			}
+			if flaky.calls != testCase.wantCall || len(attempts) != testCase.wantCall {
+				t.Fatalf("want %d attempts, got %d calls and %d events", testCase.wantCall, flaky.calls, len(attempts))
+			}
+			for idx, attempt := range attempts {
+				if attempt.Attempt != idx+1 {
+					t.Fatalf("want attempt %d, got %d", idx+1, attempt.Attempt)
+				}
+				retried := idx+1 < len(attempts)
+				if (attempt.Backoff > 0) != retried {
+					t.Fatalf("want backoff only before a retry, got %+v", attempt)
+				}
+			}
+		})
+	}
+}
+
+type sqlStateError string
+
+func (err sqlStateError) Error() string {
+	return "pq: could not serialize access"
+}
+
+func (err sqlStateError) SQLState() string {
+	return string(err)
+}
+
+// sqliteCodeError is a error of a SQLite driver with a Code method, such as modernc.org/sqlite.
+type sqliteCodeError int
+
+func (err sqliteCodeError) Error() string {
+	return "database is locked"
+}
+
+func (err sqliteCodeError) Code() int {
+	return int(err)
+}
+
+func TestIsTransientError(t *testing.T) {
+	testCases := []struct {
+		err  error
+		want bool
+	}{
+		{err: nil, want: false},
+		{err: errors.New("syntax error"), want: false},
+		{err: errors.New("database is locked"), want: false},
+		{err: sqlite3.Error{Code: sqlite3.ErrBusy}, want: true},
+		{err: fmt.Errorf("exec: %w", &sqlite3.Error{Code: sqlite3.ErrLocked}), want: true},
+		{err: sqlite3.ErrBusy, want: true},
+		{err: sqlite3.Error{Code: sqlite3.ErrConstraint}, want: false},
+		{err: sqliteCodeError(int(sqlite3.ErrBusySnapshot)), want: true},
+		{err: context.DeadlineExceeded, want: false},
+		{err: fmt.Errorf("query: %w", context.Canceled), want: false},
+		{err: driver.ErrBadConn, want: true},
+		{err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, want: true},
+		{err: sqlStateError("40001"), want: true},
+		{err: sqlStateError("23505"), want: false},
+	}
+	for _, testCase := range testCases {
+		if got := IsTransientError(testCase.err); got != testCase.want {
+			t.Fatalf("want IsTransientError(%v) %v, got %v", testCase.err, testCase.want, got)
+		}
+	}
+}
+
+func TestRetryMiddlewareGroup(t *testing.T) {
+	var attempts, failures int
+	var replyErr error
+	dri := Driver{
+		Target: fakedriver.FakeDriver{
+			ExpectedExecContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+				attempts++
+				if attempts <= failures {
+					return nil, replyErr
+				}
+				return fakedriver.FakeResult{}, nil
+			},
+		},
+		MiddlewareGroup: RetryMiddlewareGroup(RetryOptions{
+			Backoff:       Backoff{Initial: time.Millisecond},
+			BudgetReserve: 5,
+			BudgetRatio:   0.001,
+		}),
+	}
+	sql.Register("test_retry", dri)
+
+	db, err := sql.Open("test_retry", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.TODO()
+	locked := sqlite3.Error{Code: sqlite3.ErrBusy}
+
+	testCases := []struct {
+		Name         string
+		ctx          context.Context
+		query        string
+		err          error
+		failures     int
+		tx           bool
+		wantErr      bool
+		wantAttempts int
+	}{
+		{Name: "read", ctx: ctx, query: "SELECT 1", err: locked, failures: 2, wantAttempts: 3},
+		{Name: "exhausted", ctx: ctx, query: "SELECT 1", err: locked, failures: 5, wantErr: true, wantAttempts: 3},
+		{Name: "write", ctx: ctx, query: "INSERT INTO t VALUES (1)", err: locked, failures: 1, wantErr: true, wantAttempts: 1},
+		{Name: "idempotent", ctx: WithIdempotent(ctx), query: "UPDATE t SET a = 1", err: locked, failures: 1, wantAttempts: 2},
+		{Name: "permanent", ctx: ctx, query: "SELECT 1", err: errors.New("syntax error"), failures: 1, wantErr: true, wantAttempts: 1},
+		{Name: "transaction", ctx: ctx, query: "SELECT 1", err: locked, failures: 1, tx: true, wantErr: true, wantAttempts: 1},
+		// the reserve of 5 retries is drained by the cases above
+		{Name: "budget", ctx: ctx, query: "SELECT 1", err: locked, failures: 1, wantErr: true, wantAttempts: 1},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			attempts, failures, replyErr = 0, testCase.failures, testCase.err
+
+			if testCase.tx {
+				tx, err := db.BeginTx(testCase.ctx, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer tx.Rollback()
+				_, err = tx.ExecContext(testCase.ctx, testCase.query)
+				if (err != nil) != testCase.wantErr {
+					t.Fatalf("want error %v, got %v", testCase.wantErr, err)
+				}
+			} else {
+				_, err := db.ExecContext(testCase.ctx, testCase.query)
+				if (err != nil) != testCase.wantErr {
+					t.Fatalf("want error %v, got %v", testCase.wantErr, err)
+				}
+			}
+			if attempts != testCase.wantAttempts {
+				t.Fatalf("want %d attempts, got %d", testCase.wantAttempts, attempts)
+			}
+		})
+	}
+}
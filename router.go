@@ -0,0 +1,460 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wencan/middledriver/sqlparse"
+)
+
+// Route is the backend chosen for a statement by Router.
+type Route int
+
+const (
+	// RouteAuto lets Router choose by the statement and the transaction state.
+	RouteAuto Route = iota
+
+	// RoutePrimary is the primary.
+	RoutePrimary
+
+	// RouteReplica is one of the replicas.
+	RouteReplica
+)
+
+type routeKey struct{}
+
+// WithRoute returns a copy of ctx which overrides the routing of Router.
+// Statements in a transaction always go to the backend of the transaction.
+func WithRoute(ctx context.Context, route Route) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+// RouteFromContext returns the route set by WithRoute.
+func RouteFromContext(ctx context.Context) (Route, bool) {
+	route, ok := ctx.Value(routeKey{}).(Route)
+	return route, ok
+}
+
+// RouterStats are the counters of the routing decisions of Router.
+type RouterStats struct {
+	// PinnedReads are the read-only statements sent to the primary by a pinned ConsistencyToken.
+	PinnedReads int64
+
+	// ReplicaReads are the read-only statements sent to a replica.
+	ReplicaReads int64
+
+	// ReplicaFallbacks are the statements and transactions routed to a replica
+	// but sent to the primary as no replica could be connected.
+	ReplicaFallbacks int64
+
+	// Writes are the other statements, sent to the primary.
+	Writes int64
+}
+
+// Router is a driver.Connector which holds a primary and any number of replicas.
+// Each connection of Router holds a connection to the primary and, on first use, to one of the replicas.
+// Read-only statements outside transactions go to the replica, others go to the primary.
+// Read-only transactions go to the replica as a whole.
+// Writes mark the ConsistencyToken of their context, and reads of a context carrying a pinned ConsistencyToken go to the primary.
+// Prepared statements are routed on each execution.
+// A replica which fails to connect is skipped for 1s, doubled for each consecutive failure up to 1m,
+// while no replica can be connected the reads go to the primary.
+//
+// Wrap Router with Driver.NewConnector so the same MiddlewareGroup applies to all backends.
+type Router struct {
+	primary  driver.Connector
+	replicas []*routerReplica
+
+	next uint32
+
+	pinnedReads      int64
+	replicaReads     int64
+	replicaFallbacks int64
+	writes           int64
+}
+
+const (
+	routerReplicaBackoff    = time.Second
+	routerReplicaMaxBackoff = time.Minute
+)
+
+type routerReplica struct {
+	connector driver.Connector
+
+	mu        sync.Mutex
+	failures  int
+	downUntil time.Time
+}
+
+// NewRouter create a Router.
+func NewRouter(primary driver.Connector, replicas ...driver.Connector) *Router {
+	router := &Router{
+		primary: primary,
+	}
+	for _, replica := range replicas {
+		router.replicas = append(router.replicas, &routerReplica{connector: replica})
+	}
+	return router
+}
+
+// Connect implements Connector.
+func (router *Router) Connect(ctx context.Context) (driver.Conn, error) {
+	primary, err := router.primary.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &routerConn{
+		router:  router,
+		primary: primary,
+	}, nil
+}
+
+// Driver implements Connector.
+func (router *Router) Driver() driver.Driver {
+	return router.primary.Driver()
+}
+
+// Stats returns the counters of the routing decisions.
+func (router *Router) Stats() RouterStats {
+	return RouterStats{
+		PinnedReads:      atomic.LoadInt64(&router.pinnedReads),
+		ReplicaReads:     atomic.LoadInt64(&router.replicaReads),
+		ReplicaFallbacks: atomic.LoadInt64(&router.replicaFallbacks),
+		Writes:           atomic.LoadInt64(&router.writes),
+	}
+}
+
+// connectReplica connects the next replica which is not backing off.
+func (router *Router) connectReplica(ctx context.Context) (driver.Conn, error) {
+	now := time.Now()
+	err := errNoReplica
+	next := atomic.AddUint32(&router.next, 1)
+	for offset := range router.replicas {
+		replica := router.replicas[(next+uint32(offset))%uint32(len(router.replicas))]
+		if !replica.up(now) {
+			continue
+		}
+
+		var target driver.Conn
+		target, err = replica.connector.Connect(ctx)
+		if err == nil {
+			replica.succeeded()
+			return target, nil
+		}
+		// the replica is not to blame for a canceled or expired context
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		replica.failed()
+	}
+	return nil, err
+}
+
+var errNoReplica = errors.New("middledriver: no replica up")
+
+func (replica *routerReplica) up(now time.Time) bool {
+	replica.mu.Lock()
+	defer replica.mu.Unlock()
+	return !now.Before(replica.downUntil)
+}
+
+func (replica *routerReplica) failed() {
+	replica.mu.Lock()
+	defer replica.mu.Unlock()
+
+	backoff := routerReplicaBackoff
+	for idx := 0; idx < replica.failures && backoff < routerReplicaMaxBackoff; idx++ {
+		backoff *= 2
+	}
+	if backoff > routerReplicaMaxBackoff {
+		backoff = routerReplicaMaxBackoff
+	}
+	replica.failures++
+	replica.downUntil = time.Now().Add(backoff)
+}
+
+func (replica *routerReplica) succeeded() {
+	replica.mu.Lock()
+	defer replica.mu.Unlock()
+	replica.failures = 0
+	replica.downUntil = time.Time{}
+}
+
+type routerConn struct {
+	router *Router
+
+	primary driver.Conn
+
+	// replica is connected on first use
+	replica driver.Conn
+
+	// txConn is the backend of the current transaction
+	txConn driver.Conn
+}
+
+func (conn *routerConn) route(ctx context.Context, readOnly bool) Route {
+	router := conn.router
+	if len(router.replicas) == 0 {
+		return RoutePrimary
+	}
+	route, _ := RouteFromContext(ctx)
+	if route != RouteAuto {
+		return route
+	}
+
+	if !readOnly {
+		atomic.AddInt64(&router.writes, 1)
+		return RoutePrimary
+	}
+
+	token, ok := ConsistencyTokenFromContext(ctx)
+	if ok && token.Pinned() {
+		atomic.AddInt64(&router.pinnedReads, 1)
+		return RoutePrimary
+	}
+	return RouteReplica
+}
+
+// backend returns the connection of route, it falls back to the primary if no replica can be connected.
+func (conn *routerConn) backend(ctx context.Context, route Route) driver.Conn {
+	if route != RouteReplica {
+		return conn.primary
+	}
+	if conn.replica == nil {
+		replica, err := conn.router.connectReplica(ctx)
+		if err != nil {
+			atomic.AddInt64(&conn.router.replicaFallbacks, 1)
+			return conn.primary
+		}
+		conn.replica = replica
+	}
+	return conn.replica
+}
+
+// pick returns the backend of a statement, and marks the ConsistencyToken of ctx if the statement writes.
+func (conn *routerConn) pick(ctx context.Context, query string) driver.Conn {
+	dialect := sqlparse.DialectGeneric
+	info, ok := OperationInfoFromContext(ctx)
+	if ok {
+		dialect = info.Dialect
+	}
+	readOnly := sqlparse.Of(query, dialect).ReadOnly
+	if !readOnly {
+		token, ok := ConsistencyTokenFromContext(ctx)
+		if ok {
+			token.MarkWrite()
+		}
+	}
+
+	if conn.txConn != nil {
+		return conn.txConn
+	}
+	route := conn.route(ctx, readOnly)
+	backend := conn.backend(ctx, route)
+	if override, _ := RouteFromContext(ctx); override == RouteAuto && route == RouteReplica && backend != conn.primary {
+		atomic.AddInt64(&conn.router.replicaReads, 1)
+	}
+	return backend
+}
+
+// Prepare implements Conn.
+func (conn *routerConn) Prepare(query string) (driver.Stmt, error) {
+	return conn.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext implements ConnPrepareContext.
+// The query is prepared on the backend chosen now, then on the backend of each execution.
+func (conn *routerConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	backend := conn.pick(ctx, query)
+	target, err := ctxDriverPrepare(ctx, backend, query)
+	if err != nil {
+		return nil, err
+	}
+	return &routerStmt{
+		conn:  conn,
+		query: query,
+		stmts: map[driver.Conn]driver.Stmt{backend: target},
+		first: target,
+	}, nil
+}
+
+// Close implements Conn.
+func (conn *routerConn) Close() error {
+	err := conn.primary.Close()
+	if conn.replica != nil {
+		if replicaErr := conn.replica.Close(); err == nil {
+			err = replicaErr
+		}
+	}
+	return err
+}
+
+// Begin implements Conn.
+func (conn *routerConn) Begin() (driver.Tx, error) {
+	return conn.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx implements ConnBeginTx.
+func (conn *routerConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	route := RoutePrimary
+	if opts.ReadOnly {
+		route = conn.route(ctx, true)
+	} else if override, _ := RouteFromContext(ctx); override != RouteAuto {
+		route = override
+	}
+
+	backend := conn.backend(ctx, route)
+	tx, err := ctxDriverBegin(ctx, backend, opts)
+	if err != nil {
+		return nil, err
+	}
+	conn.txConn = backend
+	return routerTx{
+		conn:   conn,
+		target: tx,
+	}, nil
+}
+
+// QueryContext implements QueryerContext.
+func (conn *routerConn) QueryContext(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
+	return ctxDriverQuery(ctx, conn.pick(ctx, query), query, namedArg)
+}
+
+// ExecContext implements ExecerContext.
+func (conn *routerConn) ExecContext(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+	return ctxDriverExec(ctx, conn.pick(ctx, query), query, namedArg)
+}
+
+// Ping implements Pinger.
+func (conn *routerConn) Ping(ctx context.Context) error {
+	pinger, ok := conn.primary.(driver.Pinger)
+	if ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// ResetSession implements SessionResetter.
+func (conn *routerConn) ResetSession(ctx context.Context) error {
+	for _, backend := range []driver.Conn{conn.primary, conn.replica} {
+		resetter, ok := backend.(driver.SessionResetter)
+		if !ok {
+			continue
+		}
+		err := resetter.ResetSession(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsValid implements Validator, the connection is valid if its connections to the primary and the replica are.
+func (conn *routerConn) IsValid() bool {
+	for _, backend := range []driver.Conn{conn.primary, conn.replica} {
+		validator, ok := backend.(driver.Validator)
+		if ok && !validator.IsValid() {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckNamedValue implements NamedValueChecker.
+func (conn *routerConn) CheckNamedValue(nv *driver.NamedValue) error {
+	checker, ok := conn.primary.(driver.NamedValueChecker)
+	if !ok {
+		checker = defaultNamedValueChecker{}
+	}
+	return checker.CheckNamedValue(nv)
+}
+
+type routerTx struct {
+	conn   *routerConn
+	target driver.Tx
+}
+
+// Commit implements Tx.
+func (tx routerTx) Commit() error {
+	tx.conn.txConn = nil
+	return tx.target.Commit()
+}
+
+// Rollback implements Tx.
+func (tx routerTx) Rollback() error {
+	tx.conn.txConn = nil
+	return tx.target.Rollback()
+}
+
+// routerStmt prepares the query on the backend of each execution.
+type routerStmt struct {
+	conn  *routerConn
+	query string
+
+	stmts map[driver.Conn]driver.Stmt
+
+	// first is the statement prepared by PrepareContext
+	first driver.Stmt
+}
+
+func (stmt *routerStmt) pick(ctx context.Context) (driver.Stmt, error) {
+	backend := stmt.conn.pick(ctx, stmt.query)
+	target, ok := stmt.stmts[backend]
+	if ok {
+		return target, nil
+	}
+	target, err := ctxDriverPrepare(ctx, backend, stmt.query)
+	if err != nil {
+		return nil, err
+	}
+	stmt.stmts[backend] = target
+	return target, nil
+}
+
+// Close implements Stmt.
+func (stmt *routerStmt) Close() error {
+	var err error
+	for _, target := range stmt.stmts {
+		if closeErr := target.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// NumInput implements Stmt.
+func (stmt *routerStmt) NumInput() int {
+	return stmt.first.NumInput()
+}
+
+// Query implements Stmt.
+func (stmt *routerStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("Please update Go to 1.8+ version")
+}
+
+// Exec implements Stmt.
+func (stmt *routerStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("Please update Go to 1.8+ version")
+}
+
+// QueryContext implements StmtQueryContext.
+func (stmt *routerStmt) QueryContext(ctx context.Context, namedArg []driver.NamedValue) (driver.Rows, error) {
+	target, err := stmt.pick(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ctxDriverStmtQuery(ctx, target, namedArg)
+}
+
+// ExecContext implements StmtExecContext.
+func (stmt *routerStmt) ExecContext(ctx context.Context, namedArg []driver.NamedValue) (driver.Result, error) {
+	target, err := stmt.pick(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ctxDriverStmtExec(ctx, target, namedArg)
+}
@@ -0,0 +1,226 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/wencan/middledriver/sqlparse"
+)
+
+// newSQLiteBackends creates sqlite files with a table backend holding the name of the file.
+func newSQLiteBackends(t *testing.T, names ...string) (string, []string) {
+	dir, err := ioutil.TempDir("", "middledriver")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for _, name := range names {
+		path := filepath.Join(dir, name+".db")
+		db, err := sql.Open("sqlite3", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec("CREATE TABLE backend (name varchar(255)); INSERT INTO backend VALUES (?)", name)
+		db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return dir, paths
+}
+
+func queryBackend(ctx context.Context, querier interface {
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}) (string, error) {
+	var name string
+	err := querier.QueryRowContext(ctx, "SELECT name FROM backend LIMIT 1").Scan(&name)
+	return name, err
+}
+
+func TestRouter(t *testing.T) {
+	dir, paths := newSQLiteBackends(t, "primary", "replica")
+	defer os.RemoveAll(dir)
+
+	primary, err := DSNConnector(&sqlite3.SQLiteDriver{}, paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica, err := DSNConnector(&sqlite3.SQLiteDriver{}, paths[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dri := Driver{
+		Target:  &sqlite3.SQLiteDriver{},
+		Dialect: sqlparse.DialectSQLite,
+	}
+	router := NewRouter(primary, replica)
+	db := sql.OpenDB(dri.NewConnector(router))
+	defer db.Close()
+	ctx := context.TODO()
+
+	got, err := queryBackend(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "replica" {
+		t.Fatalf("want read from replica, got %s", got)
+	}
+
+	got, err = queryBackend(WithRoute(ctx, RoutePrimary), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "primary" {
+		t.Fatalf("want read from primary, got %s", got)
+	}
+
+	_, err = db.ExecContext(ctx, "INSERT INTO backend VALUES ('written')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	err = db.QueryRowContext(WithRoute(ctx, RoutePrimary), "SELECT count(*) FROM backend").Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("want 2 rows on primary, got %d", count)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = queryBackend(ctx, tx)
+	tx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "primary" {
+		t.Fatalf("want read in transaction from primary, got %s", got)
+	}
+
+	tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = queryBackend(ctx, tx)
+	tx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "replica" {
+		t.Fatalf("want read in read-only transaction from replica, got %s", got)
+	}
+
+	stmt, err := db.PrepareContext(ctx, "SELECT name FROM backend LIMIT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	err = stmt.QueryRowContext(ctx).Scan(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "replica" {
+		t.Fatalf("want prepared read from replica, got %s", got)
+	}
+
+	pinnedCtx := WithConsistencyToken(ctx, time.Minute)
+	_, err = db.ExecContext(pinnedCtx, "INSERT INTO backend VALUES ('pinned')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stmt.QueryRowContext(pinnedCtx).Scan(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "primary" {
+		t.Fatalf("want prepared read after write from primary, got %s", got)
+	}
+	err = stmt.QueryRowContext(ctx).Scan(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "replica" {
+		t.Fatalf("want prepared read without token from replica, got %s", got)
+	}
+
+	pinnedReads := router.Stats().PinnedReads
+	tx, err = db.BeginTx(pinnedCtx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = queryBackend(pinnedCtx, tx)
+	tx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "primary" {
+		t.Fatalf("want read-only transaction after write from primary, got %s", got)
+	}
+	if stats := router.Stats(); stats.PinnedReads != pinnedReads+1 {
+		t.Fatalf("want the pinned read-only transaction counted, got %+v", stats)
+	}
+}
+
+func TestRouter_ReplicaDown(t *testing.T) {
+	dir, paths := newSQLiteBackends(t, "primary")
+	defer os.RemoveAll(dir)
+
+	primary, err := DSNConnector(&sqlite3.SQLiteDriver{}, paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := &downConnector{}
+	router := NewRouter(primary, down)
+
+	dri := Driver{
+		Target:  &sqlite3.SQLiteDriver{},
+		Dialect: sqlparse.DialectSQLite,
+	}
+	db := sql.OpenDB(dri.NewConnector(router))
+	defer db.Close()
+	ctx := context.TODO()
+
+	for idx := 0; idx < 3; idx++ {
+		got, err := queryBackend(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "primary" {
+			t.Fatalf("want read from primary while the replica is down, got %s", got)
+		}
+	}
+	if calls := atomic.LoadInt32(&down.calls); calls != 1 {
+		t.Fatalf("want 1 connect to the replica during its backoff, got %d", calls)
+	}
+	stats := router.Stats()
+	if stats.ReplicaReads != 0 || stats.ReplicaFallbacks != 3 {
+		t.Fatalf("want 0 replica reads and 3 fallbacks, got %+v", stats)
+	}
+}
+
+func TestRouter_ReplicaCanceled(t *testing.T) {
+	router := NewRouter(&downConnector{}, &downConnector{})
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	_, err := router.connectReplica(ctx)
+	if err != context.Canceled {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+	if !router.replicas[0].up(time.Now()) {
+		t.Fatal("want replica up after a canceled connect")
+	}
+}
@@ -0,0 +1,81 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync/atomic"
+
+	"github.com/wencan/middledriver/sqlparse"
+)
+
+// SessionPolicy keeps the session state of pooled connections known.
+// A connection which executed a statement changing its session state, such as SET,
+// is restored to the baseline left by InitStatements and OnConnect or discarded when database/sql reuses it.
+type SessionPolicy struct {
+	// ResetStatements restore the defaults of the session, such as RESET ALL or DISCARD ALL on PostgreSQL,
+	// InitStatements are executed again after them.
+	// Without ResetStatements, a changed connection is discarded.
+	ResetStatements []string
+
+	// Changes reports whether a statement changes the session state, default SET, RESET and PRAGMA statements.
+	Changes func(stmt sqlparse.Statement) bool
+}
+
+func (policy *SessionPolicy) changes(stmt sqlparse.Statement) bool {
+	if policy.Changes != nil {
+		return policy.Changes(stmt)
+	}
+	for _, typ := range stmt.Types {
+		if typ == sqlparse.StatementSet || typ == sqlparse.StatementPragma {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionState tracks whether the session state of a connection left the baseline.
+type sessionState struct {
+	changed int32
+}
+
+// track marks the session changed by a successful statement.
+func (conn Conn) track(query string, err error) {
+	if conn.session == nil || err != nil {
+		return
+	}
+	if conn.driver.SessionPolicy.changes(sqlparse.Of(query, conn.driver.Dialect)) {
+		atomic.StoreInt32(&conn.session.changed, 1)
+	}
+}
+
+// ResetSession implements SessionResetter.
+func (conn Conn) ResetSession(ctx context.Context) error {
+	resetter, ok := conn.target.(driver.SessionResetter)
+	if ok {
+		err := resetter.ResetSession(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if conn.session == nil || atomic.LoadInt32(&conn.session.changed) == 0 {
+		return nil
+	}
+	policy := conn.driver.SessionPolicy
+	if len(policy.ResetStatements) == 0 {
+		return driver.ErrBadConn
+	}
+	// the reset is not a statement of the application, it bypasses the middlewares
+	for _, query := range policy.ResetStatements {
+		err := execTarget(ctx, conn.target, query)
+		if err != nil {
+			return driver.ErrBadConn
+		}
+	}
+	err := conn.driver.initConn(ctx, conn)
+	if err != nil {
+		return driver.ErrBadConn
+	}
+	atomic.StoreInt32(&conn.session.changed, 0)
+	return nil
+}
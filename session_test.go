@@ -0,0 +1,136 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/wencan/middledriver/internal/fakedriver"
+	"github.com/wencan/middledriver/sqlparse"
+)
+
+func TestSessionPolicy(t *testing.T) {
+	dir, paths := newSQLiteBackends(t, "session")
+	defer os.RemoveAll(dir)
+	target, err := DSNConnector(&sqlite3.SQLiteDriver{}, paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Name      string
+		policy    *SessionPolicy
+		query     string
+		wantCache int
+		wantConns int64
+	}{
+		{
+			Name:      "untracked",
+			query:     "PRAGMA cache_size = 5",
+			wantCache: 5,
+			wantConns: 1,
+		},
+		{
+			Name:      "unchanged",
+			policy:    &SessionPolicy{},
+			query:     "SELECT name FROM backend",
+			wantCache: 100,
+			wantConns: 1,
+		},
+		{
+			Name:      "discard",
+			policy:    &SessionPolicy{},
+			query:     "PRAGMA cache_size = 5",
+			wantCache: 100,
+			wantConns: 2,
+		},
+		{
+			Name:      "restore",
+			policy:    &SessionPolicy{ResetStatements: []string{"PRAGMA cache_size = -2000"}},
+			query:     "PRAGMA cache_size = 5",
+			wantCache: 100,
+			wantConns: 1,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			dri := Driver{
+				Target:         &sqlite3.SQLiteDriver{},
+				InitStatements: []string{"PRAGMA cache_size = 100"},
+				SessionPolicy:  testCase.policy,
+				Stats:          NewStats(),
+			}
+			db := sql.OpenDB(dri.NewConnector(target))
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+			ctx := context.TODO()
+
+			_, err := db.ExecContext(ctx, testCase.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var cache int
+			err = db.QueryRowContext(ctx, "PRAGMA cache_size").Scan(&cache)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cache != testCase.wantCache {
+				t.Fatalf("want cache_size %d, got %d", testCase.wantCache, cache)
+			}
+			if conns := dri.Stats.Snapshot().TotalConns; conns != testCase.wantConns {
+				t.Fatalf("want %d connections, got %d", testCase.wantConns, conns)
+			}
+		})
+	}
+}
+
+func TestSessionPolicy_ReadOnlyMode(t *testing.T) {
+	var mu sync.Mutex
+	var executed []string
+	target, err := fakedriver.FakeDriver{
+		ExpectedExecContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+			mu.Lock()
+			executed = append(executed, query)
+			mu.Unlock()
+			return fakedriver.FakeResult{}, nil
+		},
+	}.OpenConnector("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dri := Driver{
+		Target:          target.Driver(),
+		Dialect:         sqlparse.DialectPostgres,
+		MiddlewareGroup: NewReadOnlyMode(true).MiddlewareGroup(),
+		SessionPolicy:   &SessionPolicy{ResetStatements: []string{"DISCARD ALL"}},
+		Stats:           NewStats(),
+	}
+	db := sql.OpenDB(dri.NewConnector(target))
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	ctx := context.TODO()
+
+	for _, query := range []string{"SET search_path TO app", "SHOW search_path"} {
+		_, err = db.ExecContext(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"SET search_path TO app", "DISCARD ALL", "SHOW search_path"}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(executed, want) {
+		t.Fatalf("want executed %+v, got %+v", want, executed)
+	}
+	if conns := dri.Stats.Snapshot().TotalConns; conns != 1 {
+		t.Fatalf("want 1 connection, got %d", conns)
+	}
+}
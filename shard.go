@@ -0,0 +1,456 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+var (
+	// ErrNoShardKey is returned when a statement of a Sharder has no shard key in its context.
+	ErrNoShardKey = errors.New("middledriver: no shard key in context")
+
+	// ErrCrossShardTransaction is returned when a transaction touches a second shard without ShardingOptions.AllowCrossShardTransactions.
+	ErrCrossShardTransaction = errors.New("middledriver: cross-shard transaction")
+
+	// ErrNoShard is returned by NewSharder without shards.
+	ErrNoShard = errors.New("middledriver: no shard")
+)
+
+type shardKeyKey struct{}
+
+// WithShardKey returns a copy of ctx carrying the shard key, such as a tenant ID.
+func WithShardKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, shardKeyKey{}, key)
+}
+
+// ShardKeyFromContext returns the shard key carried by ctx.
+func ShardKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(shardKeyKey{}).(string)
+	return key, ok
+}
+
+// ShardingOptions configures a Sharder.
+type ShardingOptions struct {
+	// Map assigns keys to shards explicitly, other keys are placed by consistent hashing.
+	Map map[string]string
+
+	// VirtualNodes is the number of points of each shard on the hash ring, default 128.
+	VirtualNodes int
+
+	// AllowCrossShardTransactions lets a transaction span shards.
+	// The transactions of the shards are committed one by one, it is not atomic.
+	AllowCrossShardTransactions bool
+}
+
+// Sharder is a driver.Connector which sends each statement to the shard of the key in its context.
+// Each connection of Sharder connects to the shards on first use.
+// Transactions are bound to the shard of their first statement or of the key passed to BeginTx.
+//
+// Wrap Sharder with Driver.NewConnector so the same MiddlewareGroup applies to all shards.
+type Sharder struct {
+	shards  map[string]driver.Connector
+	names   []string
+	options ShardingOptions
+
+	ring []ringPoint
+}
+
+type ringPoint struct {
+	hash  uint64
+	shard string
+}
+
+// NewSharder create a Sharder of the named shards.
+// It returns ErrNoShard without shards, and a error if ShardingOptions.Map assigns a key to a unknown shard.
+func NewSharder(shards map[string]driver.Connector, options ShardingOptions) (*Sharder, error) {
+	if len(shards) == 0 {
+		return nil, ErrNoShard
+	}
+	for key, shard := range options.Map {
+		if _, ok := shards[shard]; !ok {
+			return nil, errors.New("middledriver: key " + key + " mapped to unknown shard " + shard)
+		}
+	}
+	if options.VirtualNodes <= 0 {
+		options.VirtualNodes = 128
+	}
+
+	sharder := &Sharder{
+		shards:  shards,
+		options: options,
+	}
+	for name := range shards {
+		sharder.names = append(sharder.names, name)
+		for idx := 0; idx < options.VirtualNodes; idx++ {
+			sharder.ring = append(sharder.ring, ringPoint{
+				hash:  shardHash(name + "#" + strconv.Itoa(idx)),
+				shard: name,
+			})
+		}
+	}
+	sort.Strings(sharder.names)
+	sort.Slice(sharder.ring, func(i, j int) bool {
+		return sharder.ring[i].hash < sharder.ring[j].hash
+	})
+	return sharder, nil
+}
+
+func shardHash(s string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(s))
+
+	// fnv spreads similar short keys poorly, mix the bits as the finalizer of murmur3
+	h := hash.Sum64()
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// ShardOf returns the name of the shard of key.
+func (sharder *Sharder) ShardOf(key string) string {
+	shard, ok := sharder.options.Map[key]
+	if ok {
+		return shard
+	}
+	if len(sharder.ring) == 0 {
+		return ""
+	}
+
+	hash := shardHash(key)
+	idx := sort.Search(len(sharder.ring), func(i int) bool {
+		return sharder.ring[i].hash >= hash
+	})
+	if idx == len(sharder.ring) {
+		idx = 0
+	}
+	return sharder.ring[idx].shard
+}
+
+// Connect implements Connector.
+func (sharder *Sharder) Connect(ctx context.Context) (driver.Conn, error) {
+	return &shardConn{
+		sharder:  sharder,
+		backends: make(map[string]driver.Conn),
+	}, nil
+}
+
+// Driver implements Connector.
+func (sharder *Sharder) Driver() driver.Driver {
+	return sharder.shards[sharder.names[0]].Driver()
+}
+
+type shardConn struct {
+	sharder *Sharder
+
+	backends map[string]driver.Conn
+
+	// txs are the transactions of the shards, in the order they began
+	txs      []driver.Tx
+	txShards []string
+	txOpts   *driver.TxOptions
+
+	// txCtx is the context of BeginTx, the transactions of the shards begin with it
+	txCtx context.Context
+}
+
+func (conn *shardConn) backend(ctx context.Context, shard string) (driver.Conn, error) {
+	backend, ok := conn.backends[shard]
+	if ok {
+		return backend, nil
+	}
+	connector, ok := conn.sharder.shards[shard]
+	if !ok {
+		return nil, errors.New("unknown shard " + shard)
+	}
+	backend, err := connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn.backends[shard] = backend
+	return backend, nil
+}
+
+// pick returns the backend of the shard key of ctx, joining it to the current transaction.
+func (conn *shardConn) pick(ctx context.Context) (driver.Conn, error) {
+	key, ok := ShardKeyFromContext(ctx)
+	if !ok {
+		if conn.txOpts != nil && len(conn.txShards) > 0 {
+			return conn.backend(ctx, conn.txShards[0])
+		}
+		return nil, ErrNoShardKey
+	}
+	shard := conn.sharder.ShardOf(key)
+	backend, err := conn.backend(ctx, shard)
+	if err != nil {
+		return nil, err
+	}
+	if conn.txOpts == nil {
+		return backend, nil
+	}
+
+	for _, txShard := range conn.txShards {
+		if txShard == shard {
+			return backend, nil
+		}
+	}
+	if len(conn.txShards) > 0 && !conn.sharder.options.AllowCrossShardTransactions {
+		return nil, ErrCrossShardTransaction
+	}
+	tx, err := ctxDriverBegin(conn.txCtx, backend, *conn.txOpts)
+	if err != nil {
+		return nil, err
+	}
+	conn.txs = append(conn.txs, tx)
+	conn.txShards = append(conn.txShards, shard)
+	return backend, nil
+}
+
+// Prepare implements Conn.
+func (conn *shardConn) Prepare(query string) (driver.Stmt, error) {
+	return conn.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext implements ConnPrepareContext.
+// The statement is prepared on the shard of each execution.
+func (conn *shardConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return &shardStmt{
+		conn:  conn,
+		query: query,
+		stmts: make(map[driver.Conn]driver.Stmt),
+	}, nil
+}
+
+// Close implements Conn.
+func (conn *shardConn) Close() error {
+	var err error
+	for _, backend := range conn.backends {
+		if closeErr := backend.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Begin implements Conn.
+func (conn *shardConn) Begin() (driver.Tx, error) {
+	return conn.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx implements ConnBeginTx.
+// Without a shard key, the transaction begins on the shard of its first statement.
+func (conn *shardConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	conn.txOpts = &opts
+	conn.txCtx = ctx
+	if _, ok := ShardKeyFromContext(ctx); ok {
+		_, err := conn.pick(ctx)
+		if err != nil {
+			conn.endTx()
+			return nil, err
+		}
+	}
+	return shardTx{conn: conn}, nil
+}
+
+func (conn *shardConn) endTx() {
+	conn.txs = nil
+	conn.txShards = nil
+	conn.txOpts = nil
+	conn.txCtx = nil
+}
+
+// QueryContext implements QueryerContext.
+func (conn *shardConn) QueryContext(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
+	backend, err := conn.pick(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ctxDriverQuery(ctx, backend, query, namedArg)
+}
+
+// ExecContext implements ExecerContext.
+func (conn *shardConn) ExecContext(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+	backend, err := conn.pick(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ctxDriverExec(ctx, backend, query, namedArg)
+}
+
+// Ping implements Pinger.
+// It pings the shard of the shard key of ctx, or all shards without a key.
+func (conn *shardConn) Ping(ctx context.Context) error {
+	shards := conn.sharder.names
+	if key, ok := ShardKeyFromContext(ctx); ok {
+		shards = []string{conn.sharder.ShardOf(key)}
+	}
+	for _, shard := range shards {
+		backend, err := conn.backend(ctx, shard)
+		if err != nil {
+			return err
+		}
+		pinger, ok := backend.(driver.Pinger)
+		if !ok {
+			continue
+		}
+		err = pinger.Ping(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResetSession implements SessionResetter.
+func (conn *shardConn) ResetSession(ctx context.Context) error {
+	for _, backend := range conn.backends {
+		resetter, ok := backend.(driver.SessionResetter)
+		if !ok {
+			continue
+		}
+		err := resetter.ResetSession(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsValid implements Validator, the connection is valid if the connections to all shards are.
+func (conn *shardConn) IsValid() bool {
+	for _, backend := range conn.backends {
+		validator, ok := backend.(driver.Validator)
+		if ok && !validator.IsValid() {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckNamedValue implements NamedValueChecker.
+// The values are checked by the connection to a shard, the first shard is connected if none is.
+func (conn *shardConn) CheckNamedValue(nv *driver.NamedValue) error {
+	var backend driver.Conn
+	for _, shard := range conn.sharder.names {
+		if backend = conn.backends[shard]; backend != nil {
+			break
+		}
+	}
+	if backend == nil {
+		backend, _ = conn.backend(context.Background(), conn.sharder.names[0])
+	}
+
+	checker, ok := backend.(driver.NamedValueChecker)
+	if !ok {
+		checker = defaultNamedValueChecker{}
+	}
+	return checker.CheckNamedValue(nv)
+}
+
+type shardTx struct {
+	conn *shardConn
+}
+
+// Commit implements Tx.
+func (tx shardTx) Commit() error {
+	defer tx.conn.endTx()
+
+	for idx, target := range tx.conn.txs {
+		err := target.Commit()
+		if err != nil {
+			for _, rest := range tx.conn.txs[idx+1:] {
+				rest.Rollback()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback implements Tx.
+func (tx shardTx) Rollback() error {
+	defer tx.conn.endTx()
+
+	var err error
+	for _, target := range tx.conn.txs {
+		if rollbackErr := target.Rollback(); err == nil {
+			err = rollbackErr
+		}
+	}
+	return err
+}
+
+// shardStmt prepares the query on the shard of each execution.
+type shardStmt struct {
+	conn  *shardConn
+	query string
+
+	stmts map[driver.Conn]driver.Stmt
+}
+
+func (stmt *shardStmt) pick(ctx context.Context) (driver.Stmt, error) {
+	backend, err := stmt.conn.pick(ctx)
+	if err != nil {
+		return nil, err
+	}
+	target, ok := stmt.stmts[backend]
+	if ok {
+		return target, nil
+	}
+	target, err = ctxDriverPrepare(ctx, backend, stmt.query)
+	if err != nil {
+		return nil, err
+	}
+	stmt.stmts[backend] = target
+	return target, nil
+}
+
+// Close implements Stmt.
+func (stmt *shardStmt) Close() error {
+	var err error
+	for _, target := range stmt.stmts {
+		if closeErr := target.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// NumInput implements Stmt.
+func (stmt *shardStmt) NumInput() int {
+	return -1
+}
+
+// Query implements Stmt.
+func (stmt *shardStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("Please update Go to 1.8+ version")
+}
+
+// Exec implements Stmt.
+func (stmt *shardStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("Please update Go to 1.8+ version")
+}
+
+// QueryContext implements StmtQueryContext.
+func (stmt *shardStmt) QueryContext(ctx context.Context, namedArg []driver.NamedValue) (driver.Rows, error) {
+	target, err := stmt.pick(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ctxDriverStmtQuery(ctx, target, namedArg)
+}
+
+// ExecContext implements StmtExecContext.
+func (stmt *shardStmt) ExecContext(ctx context.Context, namedArg []driver.NamedValue) (driver.Result, error) {
+	target, err := stmt.pick(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ctxDriverStmtExec(ctx, target, namedArg)
+}
@@ -0,0 +1,279 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/wencan/middledriver/internal/fakedriver"
+	"github.com/wencan/middledriver/sqlparse"
+)
+
+func newSharder(t *testing.T, options ShardingOptions) (*Sharder, func()) {
+	names := []string{"shard0", "shard1", "shard2"}
+	dir, paths := newSQLiteBackends(t, names...)
+
+	shards := make(map[string]driver.Connector)
+	for idx, name := range names {
+		connector, err := DSNConnector(&sqlite3.SQLiteDriver{}, paths[idx])
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards[name] = connector
+	}
+	sharder, err := NewSharder(shards, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sharder, func() { os.RemoveAll(dir) }
+}
+
+func TestSharderShardOf(t *testing.T) {
+	sharder, cleanup := newSharder(t, ShardingOptions{
+		Map: map[string]string{"vip": "shard2"},
+	})
+	defer cleanup()
+
+	if got := sharder.ShardOf("vip"); got != "shard2" {
+		t.Fatalf("want mapped shard shard2, got %s", got)
+	}
+
+	counts := make(map[string]int)
+	for idx := 0; idx < 3000; idx++ {
+		key := "tenant" + strconv.Itoa(idx)
+		shard := sharder.ShardOf(key)
+		if again := sharder.ShardOf(key); again != shard {
+			t.Fatalf("unstable shard of %s: %s, %s", key, shard, again)
+		}
+		counts[shard]++
+	}
+	for _, name := range []string{"shard0", "shard1", "shard2"} {
+		if counts[name] < 500 {
+			t.Fatalf("unbalanced shards: %v", counts)
+		}
+	}
+}
+
+func TestSharder(t *testing.T) {
+	sharder, cleanup := newSharder(t, ShardingOptions{})
+	defer cleanup()
+
+	dri := Driver{
+		Target:  &sqlite3.SQLiteDriver{},
+		Dialect: sqlparse.DialectSQLite,
+	}
+	db := sql.OpenDB(dri.NewConnector(sharder))
+	defer db.Close()
+	ctx := context.TODO()
+
+	for idx := 0; idx < 10; idx++ {
+		key := "tenant" + strconv.Itoa(idx)
+		got, err := queryBackend(WithShardKey(ctx, key), db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := sharder.ShardOf(key); got != want {
+			t.Fatalf("want %s from %s, got %s", key, want, got)
+		}
+	}
+
+	_, err := queryBackend(ctx, db)
+	if err != ErrNoShardKey {
+		t.Fatalf("want ErrNoShardKey, got %v", err)
+	}
+
+	// prepared statements follow the shard key of each execution
+	stmt, err := db.PrepareContext(ctx, "SELECT name FROM backend LIMIT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		var got string
+		err = stmt.QueryRowContext(WithShardKey(ctx, key)).Scan(&got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := sharder.ShardOf(key); got != want {
+			t.Fatalf("want stmt of %s from %s, got %s", key, want, got)
+		}
+	}
+}
+
+// shardKeys returns two keys in different shards.
+func shardKeys(sharder *Sharder) (string, string) {
+	first := "tenant0"
+	for idx := 1; ; idx++ {
+		key := "tenant" + strconv.Itoa(idx)
+		if sharder.ShardOf(key) != sharder.ShardOf(first) {
+			return first, key
+		}
+	}
+}
+
+func TestSharderTransaction(t *testing.T) {
+	sharder, cleanup := newSharder(t, ShardingOptions{})
+	defer cleanup()
+
+	db := sql.OpenDB(Driver{Target: &sqlite3.SQLiteDriver{}}.NewConnector(sharder))
+	defer db.Close()
+	ctx := context.TODO()
+	first, second := shardKeys(sharder)
+
+	tx, err := db.BeginTx(WithShardKey(ctx, first), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// statements without a key stay in the shard of the transaction
+	got, err := queryBackend(ctx, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sharder.ShardOf(first); got != want {
+		t.Fatalf("want transaction in %s, got %s", want, got)
+	}
+	_, err = tx.ExecContext(WithShardKey(ctx, second), "INSERT INTO backend VALUES ('x')")
+	if err != ErrCrossShardTransaction {
+		t.Fatalf("want ErrCrossShardTransaction, got %v", err)
+	}
+	err = tx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSharderCrossShardTransaction(t *testing.T) {
+	sharder, cleanup := newSharder(t, ShardingOptions{AllowCrossShardTransactions: true})
+	defer cleanup()
+
+	db := sql.OpenDB(Driver{Target: &sqlite3.SQLiteDriver{}}.NewConnector(sharder))
+	defer db.Close()
+	ctx := context.TODO()
+	first, second := shardKeys(sharder)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{first, second} {
+		_, err = tx.ExecContext(WithShardKey(ctx, key), "INSERT INTO backend VALUES (?)", key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{first, second} {
+		var count int
+		err = db.QueryRowContext(WithShardKey(ctx, key), "SELECT count(*) FROM backend WHERE name = ?", key).Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Fatalf("want %s committed in %s, got %d rows", key, sharder.ShardOf(key), count)
+		}
+	}
+}
+
+func TestNewSharderInvalid(t *testing.T) {
+	_, err := NewSharder(nil, ShardingOptions{})
+	if err != ErrNoShard {
+		t.Fatalf("want ErrNoShard, got %v", err)
+	}
+
+	shards := map[string]driver.Connector{"shard0": &downConnector{}}
+	_, err = NewSharder(shards, ShardingOptions{Map: map[string]string{"vip": "shard1"}})
+	if err == nil {
+		t.Fatal("want error for a key mapped to a unknown shard")
+	}
+}
+
+type shardTestKey struct{}
+
+// shardTestConn records the contexts of BeginTx and converts shardTestKey values.
+type shardTestConn struct {
+	fakedriver.FakeConn
+
+	beginCtxs *[]context.Context
+}
+
+func (conn shardTestConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	*conn.beginCtxs = append(*conn.beginCtxs, ctx)
+	return fakedriver.FakeTx{}, nil
+}
+
+func (conn shardTestConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if _, ok := nv.Value.(shardTestKey); ok {
+		nv.Value = "converted"
+		return nil
+	}
+	return driver.ErrSkip
+}
+
+type shardTestConnector struct {
+	conn driver.Conn
+}
+
+func (connector shardTestConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return connector.conn, nil
+}
+
+func (connector shardTestConnector) Driver() driver.Driver {
+	return fakedriver.FakeDriver{}
+}
+
+func TestSharderConn(t *testing.T) {
+	fakeConnector, err := fakedriver.FakeDriver{
+		ExpectedExecContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+			return fakedriver.FakeResult{}, nil
+		},
+	}.OpenConnector("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := fakeConnector.Connect(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var beginCtxs []context.Context
+	sharder, err := NewSharder(map[string]driver.Connector{
+		"shard0": shardTestConnector{conn: shardTestConn{FakeConn: target.(fakedriver.FakeConn), beginCtxs: &beginCtxs}},
+	}, ShardingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := sharder.Connect(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// values are checked by the connection to the shard
+	nv := &driver.NamedValue{Ordinal: 1, Value: shardTestKey{}}
+	err = conn.(driver.NamedValueChecker).CheckNamedValue(nv)
+	if err != nil || nv.Value != "converted" {
+		t.Fatalf("want value converted by the shard, got %v, %v", nv.Value, err)
+	}
+
+	// the transaction of the shard begins with the context of BeginTx
+	beginCtx := context.WithValue(context.TODO(), shardTestKey{}, "begin")
+	tx, err := conn.(driver.ConnBeginTx).BeginTx(beginCtx, driver.TxOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	_, err = conn.(driver.ExecerContext).ExecContext(WithShardKey(context.TODO(), "tenant"), "INSERT INTO t VALUES (1)", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(beginCtxs) != 1 || beginCtxs[0].Value(shardTestKey{}) != "begin" {
+		t.Fatalf("want 1 transaction begun with the context of BeginTx, got %+v", beginCtxs)
+	}
+}
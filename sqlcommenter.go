@@ -0,0 +1,120 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// SQLCommentTags are the values written into sqlcommenter comments, empty values are omitted.
+// See https://google.github.io/sqlcommenter/spec/.
+type SQLCommentTags struct {
+	Application string
+	Controller  string
+	Action      string
+	Route       string
+	Framework   string
+	TraceParent string
+	TraceState  string
+}
+
+type sqlCommentTagsKey struct{}
+
+// WithSQLCommentTags returns a copy of ctx carrying tags.
+func WithSQLCommentTags(ctx context.Context, tags SQLCommentTags) context.Context {
+	return context.WithValue(ctx, sqlCommentTagsKey{}, tags)
+}
+
+// SQLCommentTagsFromContext returns the tags carried by ctx.
+func SQLCommentTagsFromContext(ctx context.Context) (SQLCommentTags, bool) {
+	tags, ok := ctx.Value(sqlCommentTagsKey{}).(SQLCommentTags)
+	return tags, ok
+}
+
+// SQLCommenterOptions configures the sqlcommenter middlewares.
+type SQLCommenterOptions struct {
+	// Application is used when the context does not carry one.
+	Application string
+
+	// DBDriver is written as the db_driver tag, optional.
+	DBDriver string
+
+	// Extra returns additional tags from ctx, optional.
+	Extra func(ctx context.Context) map[string]string
+}
+
+// SQLCommenterMiddlewareGroup creates the middlewares which append a sqlcommenter comment
+// built from the context to every query.
+// Prepared statements are tagged with the context passed to PrepareContext.
+func SQLCommenterMiddlewareGroup(options SQLCommenterOptions) MiddlewareGroup {
+	return MiddlewareGroup{
+		QueryContextMiddleware: func(next QueryContextFunc) QueryContextFunc {
+			return func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
+				return next(ctx, options.comment(ctx, query), namedArg)
+			}
+		},
+		ExecContextMiddleware: func(next ExecContextFunc) ExecContextFunc {
+			return func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+				return next(ctx, options.comment(ctx, query), namedArg)
+			}
+		},
+		PrepareContextMiddleware: func(next PrepareContextFunc) PrepareContextFunc {
+			return func(ctx context.Context, query string) (driver.Stmt, error) {
+				return next(ctx, options.comment(ctx, query))
+			}
+		},
+	}
+}
+
+func (options SQLCommenterOptions) comment(ctx context.Context, query string) string {
+	// the spec does not allow to comment a commented query.
+	if strings.Contains(query, "/*") {
+		return query
+	}
+
+	tags, _ := SQLCommentTagsFromContext(ctx)
+	if tags.Application == "" {
+		tags.Application = options.Application
+	}
+	values := map[string]string{
+		"application": tags.Application,
+		"controller":  tags.Controller,
+		"action":      tags.Action,
+		"route":       tags.Route,
+		"framework":   tags.Framework,
+		"traceparent": tags.TraceParent,
+		"tracestate":  tags.TraceState,
+		"db_driver":   options.DBDriver,
+	}
+	if options.Extra != nil {
+		for key, value := range options.Extra(ctx) {
+			values[key] = value
+		}
+	}
+
+	pairs := make([]string, 0, len(values))
+	for key, value := range values {
+		if value == "" {
+			continue
+		}
+		pairs = append(pairs, sqlCommentEscape(key)+"='"+strings.Replace(sqlCommentEscape(value), "'", "\\'", -1)+"'")
+	}
+	if len(pairs) == 0 {
+		return query
+	}
+	sort.Strings(pairs)
+
+	trimmed := strings.TrimRight(query, " \t\r\n")
+	suffix := ""
+	if strings.HasSuffix(trimmed, ";") {
+		trimmed = strings.TrimRight(trimmed[:len(trimmed)-1], " \t\r\n")
+		suffix = ";"
+	}
+	return trimmed + " /*" + strings.Join(pairs, ",") + "*/" + suffix
+}
+
+func sqlCommentEscape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
@@ -0,0 +1,105 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+
+	"github.com/wencan/middledriver/internal/fakedriver"
+)
+
+func TestSQLCommenterMiddlewareGroup(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		DriverName string
+		Options    SQLCommenterOptions
+		Tags       *SQLCommentTags
+		Prepare    bool
+		Query      string
+		WantQuery  string
+	}{
+		{
+			Name:       "test_sqlcommenter_exec",
+			DriverName: "test_sqlcommenter_exec",
+			Options:    SQLCommenterOptions{Application: "billing"},
+			Tags: &SQLCommentTags{
+				Controller:  "invoice",
+				Action:      "pay now",
+				TraceParent: "00-5bd66ef5095369c7b0d1f8f4bd33716a-c532cb4098ac3dd2-01",
+			},
+			Query:     "UPDATE invoices SET paid = 1;",
+			WantQuery: "UPDATE invoices SET paid = 1 /*action='pay%20now',application='billing',controller='invoice',traceparent='00-5bd66ef5095369c7b0d1f8f4bd33716a-c532cb4098ac3dd2-01'*/;",
+		},
+		{
+			Name:       "test_sqlcommenter_stmt",
+			DriverName: "test_sqlcommenter_stmt",
+			Options: SQLCommenterOptions{
+				Extra: func(ctx context.Context) map[string]string {
+					return map[string]string{"team": "o'neil"}
+				},
+			},
+			Prepare:   true,
+			Query:     "UPDATE invoices SET paid = ?",
+			WantQuery: "UPDATE invoices SET paid = ? /*team='o%27neil'*/",
+		},
+		{
+			Name:       "test_sqlcommenter_commented",
+			DriverName: "test_sqlcommenter_commented",
+			Options:    SQLCommenterOptions{Application: "billing"},
+			Query:      "UPDATE invoices SET paid = 1 /* manual */",
+			WantQuery:  "UPDATE invoices SET paid = 1 /* manual */",
+		},
+		{
+			Name:       "test_sqlcommenter_empty",
+			DriverName: "test_sqlcommenter_empty",
+			Query:      "UPDATE invoices SET paid = 1",
+			WantQuery:  "UPDATE invoices SET paid = 1",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			dri := Driver{
+				Target: fakedriver.FakeDriver{
+					ExpectedExecContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+						if query != testCase.WantQuery {
+							return nil, fmt.Errorf("want query %s, got %s", testCase.WantQuery, query)
+						}
+						return fakedriver.FakeResult{}, nil
+					},
+				},
+				MiddlewareGroup: SQLCommenterMiddlewareGroup(testCase.Options),
+			}
+			sql.Register(testCase.DriverName, dri)
+
+			db, err := sql.Open(testCase.DriverName, "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			ctx := context.TODO()
+			if testCase.Tags != nil {
+				ctx = WithSQLCommentTags(ctx, *testCase.Tags)
+			}
+			if testCase.Prepare {
+				stmt, err := db.PrepareContext(ctx, testCase.Query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer stmt.Close()
+				_, err = stmt.ExecContext(ctx, 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			_, err = db.ExecContext(ctx, testCase.Query)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
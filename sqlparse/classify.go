@@ -0,0 +1,518 @@
+package sqlparse
+
+import (
+	"strings"
+
+	"github.com/wencan/middledriver/internal/boundedcache"
+)
+
+// StatementType is the type of a statement.
+type StatementType int
+
+const (
+	// StatementUnknown is a statement which is not recognized.
+	StatementUnknown StatementType = iota
+
+	// StatementSelect is SELECT, VALUES and TABLE.
+	StatementSelect
+
+	// StatementInsert is INSERT, REPLACE and UPSERT.
+	StatementInsert
+
+	// StatementUpdate is UPDATE.
+	StatementUpdate
+
+	// StatementDelete is DELETE.
+	StatementDelete
+
+	// StatementMerge is MERGE.
+	StatementMerge
+
+	// StatementDDL is CREATE, ALTER, DROP, TRUNCATE, RENAME, COMMENT, GRANT and REVOKE.
+	StatementDDL
+
+	// StatementTransaction is BEGIN, START, COMMIT, ROLLBACK, SAVEPOINT, RELEASE and END.
+	StatementTransaction
+
+	// StatementSet is SET and RESET of session state.
+	StatementSet
+
+	// StatementPragma is SQLite PRAGMA.
+	StatementPragma
+
+	// StatementShow is SHOW, DESCRIBE and EXPLAIN without ANALYZE.
+	StatementShow
+
+	// StatementCall is CALL, EXEC and EXECUTE, their effects are unknown.
+	StatementCall
+
+	// StatementOther is any other recognized statement, such as VACUUM, ANALYZE or USE.
+	StatementOther
+)
+
+var statementTypeNames = map[StatementType]string{
+	StatementUnknown:     "unknown",
+	StatementSelect:      "select",
+	StatementInsert:      "insert",
+	StatementUpdate:      "update",
+	StatementDelete:      "delete",
+	StatementMerge:       "merge",
+	StatementDDL:         "ddl",
+	StatementTransaction: "transaction",
+	StatementSet:         "set",
+	StatementPragma:      "pragma",
+	StatementShow:        "show",
+	StatementCall:        "call",
+	StatementOther:       "other",
+}
+
+// String returns the lower case name of the type.
+func (typ StatementType) String() string {
+	return statementTypeNames[typ]
+}
+
+// IsWrite reports whether statements of the type may modify data, schema or locks.
+func (typ StatementType) IsWrite() bool {
+	switch typ {
+	case StatementInsert, StatementUpdate, StatementDelete, StatementMerge, StatementDDL, StatementCall, StatementOther, StatementUnknown:
+		return true
+	}
+	return false
+}
+
+var leadingKeywords = map[string]StatementType{
+	"SELECT":    StatementSelect,
+	"VALUES":    StatementSelect,
+	"TABLE":     StatementSelect,
+	"INSERT":    StatementInsert,
+	"REPLACE":   StatementInsert,
+	"UPSERT":    StatementInsert,
+	"UPDATE":    StatementUpdate,
+	"DELETE":    StatementDelete,
+	"MERGE":     StatementMerge,
+	"CREATE":    StatementDDL,
+	"ALTER":     StatementDDL,
+	"DROP":      StatementDDL,
+	"TRUNCATE":  StatementDDL,
+	"RENAME":    StatementDDL,
+	"COMMENT":   StatementDDL,
+	"GRANT":     StatementDDL,
+	"REVOKE":    StatementDDL,
+	"BEGIN":     StatementTransaction,
+	"START":     StatementTransaction,
+	"COMMIT":    StatementTransaction,
+	"ROLLBACK":  StatementTransaction,
+	"SAVEPOINT": StatementTransaction,
+	"RELEASE":   StatementTransaction,
+	"END":       StatementTransaction,
+	"SET":       StatementSet,
+	"RESET":     StatementSet,
+	"PRAGMA":    StatementPragma,
+	"SHOW":      StatementShow,
+	"DESCRIBE":  StatementShow,
+	"DESC":      StatementShow,
+	"EXPLAIN":   StatementShow,
+	"CALL":      StatementCall,
+	"EXEC":      StatementCall,
+	"EXECUTE":   StatementCall,
+	"DO":        StatementCall,
+	"VACUUM":    StatementOther,
+	"ANALYZE":   StatementOther,
+	"USE":       StatementOther,
+	"LOCK":      StatementOther,
+	"UNLOCK":    StatementOther,
+	"ATTACH":    StatementOther,
+	"DETACH":    StatementOther,
+	"REINDEX":   StatementOther,
+	"LISTEN":    StatementOther,
+	"NOTIFY":    StatementOther,
+}
+
+// Statement is the classification of a query.
+type Statement struct {
+	// Type is the type of the first statement.
+	Type StatementType
+
+	// Types are the types of all statements in the query.
+	Types []StatementType
+
+	// Tables are the tables referenced by the query, unquoted identifiers are lower case.
+	Tables []string
+
+	// Placeholders is the number of placeholders in the query.
+	Placeholders int
+
+	// Locking reports whether a SELECT locks rows, such as SELECT ... FOR UPDATE.
+	Locking bool
+
+	// ReadOnly reports whether no statement in the query can modify data, schema, locks,
+	// or the state of the session or transaction.
+	ReadOnly bool
+
+	// Executable reports whether the query has MySQL executable comments, /*! */ or /*M! */,
+	// whose content the server runs depending on its version.
+	Executable bool
+}
+
+// Multi reports whether the query contains more than one statement.
+func (stmt Statement) Multi() bool {
+	return len(stmt.Types) > 1
+}
+
+// Classify classifies query following the rules of dialect.
+func Classify(query string, dialect Dialect) Statement {
+	var stmt Statement
+	var tokens []Token
+	for _, token := range Tokenize(query, dialect) {
+		if token.Significant() {
+			tokens = append(tokens, token)
+		} else if token.Kind == TokenExecutableComment && token.Text != "*/" && !strings.HasPrefix(token.Text, "/*+") {
+			stmt.Executable = true
+		}
+	}
+
+	stmt.ReadOnly = true
+	for start := 0; start < len(tokens); {
+		end := start
+		depth := 0
+		for ; end < len(tokens); end++ {
+			if tokens[end].Kind == TokenPunct {
+				if tokens[end].Text == "(" {
+					depth++
+				} else if tokens[end].Text == ")" {
+					depth--
+				} else if tokens[end].Text == ";" && depth <= 0 {
+					break
+				}
+			}
+		}
+		if end > start {
+			classifyStatement(&stmt, tokens[start:end])
+		}
+		start = end + 1
+	}
+
+	if len(stmt.Types) == 0 {
+		stmt.ReadOnly = false
+		return stmt
+	}
+	stmt.Type = stmt.Types[0]
+	return stmt
+}
+
+func classifyStatement(stmt *Statement, tokens []Token) {
+	for _, token := range tokens {
+		if token.Kind == TokenPlaceholder {
+			stmt.Placeholders++
+		}
+	}
+
+	// skip the parentheses around the statement, such as (SELECT 1) UNION (SELECT 2)
+	head := 0
+	for head < len(tokens) && tokens[head].Text == "(" {
+		head++
+	}
+
+	keyword := ""
+	if head < len(tokens) {
+		keyword = tokens[head].Keyword()
+	}
+
+	var ctes map[string]bool
+	cteWrite := false
+	if keyword == "WITH" {
+		ctes, cteWrite, head = skipWith(tokens, head+1)
+		keyword = ""
+		if head < len(tokens) {
+			keyword = tokens[head].Keyword()
+		}
+	}
+
+	typ, ok := leadingKeywords[keyword]
+	if !ok {
+		typ = StatementUnknown
+	}
+
+	write := typ.IsWrite()
+	switch typ {
+	case StatementShow:
+		// EXPLAIN ANALYZE executes the statement
+		if keyword == "EXPLAIN" {
+			for _, token := range tokens[head+1:] {
+				if token.Keyword() == "ANALYZE" {
+					write = true
+					break
+				}
+			}
+		}
+	case StatementSelect:
+		locking, into := selectEffects(tokens[head:])
+		if locking {
+			stmt.Locking = true
+		}
+		write = locking || into
+	case StatementTransaction, StatementSet, StatementPragma:
+		// transaction and session state is not data, but the statement must run on the connection it is meant for
+		write = true
+	}
+	if write || cteWrite {
+		stmt.ReadOnly = false
+	}
+
+	stmt.Types = append(stmt.Types, typ)
+	for _, table := range referencedTables(tokens, typ) {
+		if ctes[table] {
+			continue
+		}
+		stmt.Tables = appendUnique(stmt.Tables, table)
+	}
+}
+
+// skipWith skips the common table expressions.
+// It returns their names, whether one of them modifies data, and the position of the main statement.
+func skipWith(tokens []Token, pos int) (map[string]bool, bool, int) {
+	ctes := make(map[string]bool)
+	write := false
+	if pos < len(tokens) && tokens[pos].Keyword() == "RECURSIVE" {
+		pos++
+	}
+	for pos < len(tokens) {
+		ctes[tokens[pos].Ident()] = true
+		pos++
+		// the column list
+		if pos < len(tokens) && tokens[pos].Text == "(" {
+			pos = skipGroup(tokens, pos)
+		}
+		// AS [NOT] MATERIALIZED
+		for pos < len(tokens) && tokens[pos].Text != "(" {
+			pos++
+		}
+		if pos+1 < len(tokens) && leadingKeywords[tokens[pos+1].Keyword()].IsWrite() {
+			write = true
+		}
+		pos = skipGroup(tokens, pos)
+		if pos < len(tokens) && tokens[pos].Text == "," {
+			pos++
+			continue
+		}
+		break
+	}
+	return ctes, write, pos
+}
+
+// skipGroup returns the position after the parenthesized group at pos.
+func skipGroup(tokens []Token, pos int) int {
+	depth := 0
+	for ; pos < len(tokens); pos++ {
+		if tokens[pos].Text == "(" {
+			depth++
+		} else if tokens[pos].Text == ")" {
+			depth--
+			if depth == 0 {
+				return pos + 1
+			}
+		}
+	}
+	return pos
+}
+
+// selectEffects reports whether a SELECT locks rows or creates a table with SELECT ... INTO.
+func selectEffects(tokens []Token) (locking bool, into bool) {
+	depth := 0
+	for idx, token := range tokens {
+		switch token.Text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+		if depth != 0 {
+			continue
+		}
+		switch token.Keyword() {
+		case "INTO":
+			// MySQL SELECT ... INTO @var only assigns variables
+			if idx+1 >= len(tokens) || !strings.HasPrefix(tokens[idx+1].Text, "@") {
+				into = true
+			}
+		case "FOR":
+			if idx+1 < len(tokens) {
+				switch tokens[idx+1].Keyword() {
+				case "UPDATE", "SHARE", "NO", "KEY":
+					locking = true
+				}
+			}
+		case "LOCK":
+			// MySQL LOCK IN SHARE MODE
+			locking = true
+		}
+	}
+	return locking, into
+}
+
+// functionsWithFrom are the functions using FROM in their arguments, such as EXTRACT(YEAR FROM t).
+var functionsWithFrom = map[string]bool{
+	"EXTRACT": true, "SUBSTRING": true, "SUBSTR": true, "TRIM": true, "POSITION": true, "OVERLAY": true,
+}
+
+// referencedTables returns the names following FROM, JOIN, INTO, UPDATE, TABLE and USING.
+func referencedTables(tokens []Token, typ StatementType) []string {
+	var tables []string
+	// the keywords before the open parentheses
+	var parens []string
+	for idx := 0; idx < len(tokens); idx++ {
+		switch tokens[idx].Text {
+		case "(":
+			before := ""
+			if idx > 0 {
+				before = tokens[idx-1].Keyword()
+			}
+			parens = append(parens, before)
+			continue
+		case ")":
+			if len(parens) > 0 {
+				parens = parens[:len(parens)-1]
+			}
+			continue
+		}
+
+		keyword := tokens[idx].Keyword()
+		switch keyword {
+		case "FROM":
+			if len(parens) > 0 && functionsWithFrom[parens[len(parens)-1]] {
+				continue
+			}
+			if idx > 0 && tokens[idx-1].Keyword() == "DISTINCT" {
+				continue
+			}
+		case "JOIN":
+		case "INTO":
+			// MySQL SELECT ... INTO OUTFILE and DUMPFILE write files, not tables
+			if idx+1 < len(tokens) && (tokens[idx+1].Keyword() == "OUTFILE" || tokens[idx+1].Keyword() == "DUMPFILE") {
+				continue
+			}
+		case "UPDATE":
+			if typ != StatementUpdate {
+				continue
+			}
+		case "TABLE":
+			if typ == StatementSelect && idx > 0 {
+				continue
+			}
+		case "USING":
+			if typ != StatementDelete && typ != StatementMerge {
+				continue
+			}
+		default:
+			continue
+		}
+
+		for pos := idx + 1; pos < len(tokens); {
+			// skip modifiers, such as IF NOT EXISTS, ONLY and LATERAL
+			for pos < len(tokens) && tableModifiers[tokens[pos].Keyword()] {
+				pos++
+			}
+			name, next := qualifiedName(tokens, pos)
+			if name == "" {
+				break
+			}
+			tables = appendUnique(tables, name)
+			pos = next
+
+			// FROM a x, b y: skip the alias, then continue after the comma
+			if keyword != "FROM" && keyword != "UPDATE" && keyword != "USING" {
+				break
+			}
+			for pos < len(tokens) && (tokens[pos].Kind == TokenWord || tokens[pos].Kind == TokenQuotedIdent) && !isClauseKeyword(tokens[pos].Keyword()) {
+				pos++
+			}
+			if pos < len(tokens) && tokens[pos].Text == "," {
+				pos++
+				continue
+			}
+			break
+		}
+	}
+	return tables
+}
+
+var tableModifiers = map[string]bool{
+	"IF": true, "NOT": true, "EXISTS": true, "ONLY": true, "LATERAL": true, "IGNORE": true, "LOW_PRIORITY": true,
+}
+
+// qualifiedName reads a name such as schema.table at pos.
+func qualifiedName(tokens []Token, pos int) (string, int) {
+	name := ""
+	for pos < len(tokens) {
+		token := tokens[pos]
+		if token.Kind != TokenWord && token.Kind != TokenQuotedIdent || token.Kind == TokenWord && isClauseKeyword(token.Keyword()) {
+			break
+		}
+		name += token.Ident()
+		pos++
+		if pos < len(tokens) && tokens[pos].Text == "." {
+			name += "."
+			pos++
+			continue
+		}
+		break
+	}
+	return name, pos
+}
+
+var clauseKeywords = map[string]bool{
+	"SELECT": true, "WHERE": true, "SET": true, "VALUES": true, "ON": true, "USING": true,
+	"JOIN": true, "INNER": true, "LEFT": true, "RIGHT": true, "FULL": true, "CROSS": true, "NATURAL": true, "OUTER": true,
+	"GROUP": true, "ORDER": true, "HAVING": true, "LIMIT": true, "OFFSET": true, "UNION": true, "EXCEPT": true, "INTERSECT": true,
+	"WINDOW": true, "RETURNING": true, "FOR": true, "DEFAULT": true, "AS": true, "WITH": true, "FROM": true, "INTO": true,
+}
+
+func isClauseKeyword(keyword string) bool {
+	return clauseKeywords[keyword]
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, item := range list {
+		if item == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// Cache caches the classifications per query string and dialect.
+type Cache struct {
+	statements *boundedcache.Cache
+}
+
+type cacheKey struct {
+	query   string
+	dialect Dialect
+}
+
+// NewCache create a Cache holding at most size classifications.
+func NewCache(size int) *Cache {
+	return &Cache{
+		statements: boundedcache.New(size),
+	}
+}
+
+// Classify returns the classification of query.
+// The returned Statement is shared, its slices must not be modified.
+func (cache *Cache) Classify(query string, dialect Dialect) Statement {
+	key := cacheKey{query: query, dialect: dialect}
+	if stmt, ok := cache.statements.Load(key); ok {
+		return stmt.(Statement)
+	}
+
+	stmt := Classify(query, dialect)
+	cache.statements.Store(key, stmt)
+	return stmt
+}
+
+var defaultCache = NewCache(4096)
+
+// Of returns the classification of query from the default cache.
+func Of(query string, dialect Dialect) Statement {
+	return defaultCache.Classify(query, dialect)
+}
@@ -0,0 +1,209 @@
+package sqlparse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	testCases := []struct {
+		Name           string
+		Dialect        Dialect
+		Query          string
+		WantType       StatementType
+		WantTypes      []StatementType
+		WantTables     []string
+		WantReadOnly   bool
+		WantLocking    bool
+		WantExecutable bool
+	}{
+		{
+			Name:         "test_classify_select",
+			Query:        "SELECT u.name, EXTRACT(YEAR FROM u.born) FROM Users u, items i JOIN orders o ON o.user_id = u.id WHERE u.id = ?",
+			WantType:     StatementSelect,
+			WantTables:   []string{"users", "items", "orders"},
+			WantReadOnly: true,
+		},
+		{
+			Name:        "test_classify_select_for_update",
+			Query:       "SELECT * FROM accounts WHERE id = $1 FOR UPDATE",
+			Dialect:     DialectPostgres,
+			WantType:    StatementSelect,
+			WantTables:  []string{"accounts"},
+			WantLocking: true,
+		},
+		{
+			Name:           "test_classify_executable_into",
+			Query:          "SELECT * FROM t /*!50000 INTO OUTFILE '/tmp/x' */",
+			Dialect:        DialectMySQL,
+			WantType:       StatementSelect,
+			WantTables:     []string{"t"},
+			WantExecutable: true,
+		},
+		{
+			Name:           "test_classify_executable_for_update",
+			Query:          "SELECT * FROM t /*! FOR UPDATE */",
+			Dialect:        DialectMySQL,
+			WantType:       StatementSelect,
+			WantTables:     []string{"t"},
+			WantLocking:    true,
+			WantExecutable: true,
+		},
+		{
+			Name:         "test_classify_hint",
+			Query:        "SELECT /*+ MAX_EXECUTION_TIME(1000) */ * FROM t",
+			Dialect:      DialectMySQL,
+			WantType:     StatementSelect,
+			WantTables:   []string{"t"},
+			WantReadOnly: true,
+		},
+		{
+			Name:       "test_classify_insert",
+			Query:      "INSERT INTO public.users (id, name) VALUES (?, ?) ON DUPLICATE KEY UPDATE name = ?",
+			WantType:   StatementInsert,
+			WantTables: []string{"public.users"},
+		},
+		{
+			Name:       "test_classify_update",
+			Query:      "UPDATE users SET name = 'x' WHERE id IN (SELECT user_id FROM bans)",
+			WantType:   StatementUpdate,
+			WantTables: []string{"users", "bans"},
+		},
+		{
+			Name:       "test_classify_delete_using",
+			Query:      "DELETE FROM users USING bans WHERE users.id = bans.user_id",
+			WantType:   StatementDelete,
+			WantTables: []string{"users", "bans"},
+		},
+		{
+			Name:       "test_classify_ddl",
+			Query:      "CREATE TABLE IF NOT EXISTS \"Users\" (id int)",
+			WantType:   StatementDDL,
+			WantTables: []string{"Users"},
+		},
+		{
+			Name:         "test_classify_with",
+			Query:        "WITH recent(id) AS (SELECT id FROM orders WHERE day > ?) SELECT * FROM recent JOIN items ON items.order_id = recent.id",
+			WantType:     StatementSelect,
+			WantTables:   []string{"orders", "items"},
+			WantReadOnly: true,
+		},
+		{
+			Name:       "test_classify_with_delete",
+			Query:      "WITH gone AS (DELETE FROM orders RETURNING id) SELECT count(*) FROM gone",
+			WantType:   StatementSelect,
+			WantTables: []string{"orders"},
+		},
+		{
+			Name:       "test_classify_multi",
+			Query:      "SELECT 1; DROP TABLE users; -- bye",
+			WantType:   StatementSelect,
+			WantTypes:  []StatementType{StatementSelect, StatementDDL},
+			WantTables: []string{"users"},
+		},
+		{
+			Name:         "test_classify_explain",
+			Query:        "EXPLAIN SELECT * FROM users",
+			WantType:     StatementShow,
+			WantTables:   []string{"users"},
+			WantReadOnly: true,
+		},
+		{
+			Name:     "test_classify_set",
+			Query:    "SET search_path TO app",
+			WantType: StatementSet,
+		},
+		{
+			Name:     "test_classify_lock",
+			Query:    "LOCK TABLES accounts WRITE",
+			WantType: StatementOther,
+		},
+		{
+			Name:     "test_classify_unlock",
+			Query:    "UNLOCK TABLES",
+			WantType: StatementOther,
+		},
+		{
+			Name:     "test_classify_attach",
+			Query:    "ATTACH DATABASE 'other.db' AS other",
+			WantType: StatementOther,
+		},
+		{
+			Name:     "test_classify_detach",
+			Query:    "DETACH DATABASE other",
+			WantType: StatementOther,
+		},
+		{
+			Name:     "test_classify_use",
+			Query:    "USE app",
+			WantType: StatementOther,
+		},
+		{
+			Name:     "test_classify_listen",
+			Query:    "LISTEN events",
+			WantType: StatementOther,
+		},
+		{
+			Name:     "test_classify_notify",
+			Query:    "NOTIFY events",
+			WantType: StatementOther,
+		},
+		{
+			Name:     "test_classify_vacuum",
+			Query:    "VACUUM",
+			WantType: StatementOther,
+		},
+		{
+			Name:     "test_classify_analyze",
+			Query:    "ANALYZE users",
+			WantType: StatementOther,
+		},
+		{
+			Name:     "test_classify_reindex",
+			Query:    "REINDEX users",
+			WantType: StatementOther,
+		},
+		{
+			Name:     "test_classify_begin",
+			Query:    "BEGIN",
+			WantType: StatementTransaction,
+		},
+		{
+			Name:     "test_classify_commit",
+			Query:    "COMMIT",
+			WantType: StatementTransaction,
+		},
+		{
+			Name:     "test_classify_empty",
+			Query:    " -- nothing",
+			WantType: StatementUnknown,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			stmt := Of(testCase.Query, testCase.Dialect)
+			if stmt.Type != testCase.WantType {
+				t.Fatalf("want type %s, got %s", testCase.WantType, stmt.Type)
+			}
+			if testCase.WantTypes != nil && !reflect.DeepEqual(testCase.WantTypes, stmt.Types) {
+				t.Fatalf("want types %+v, got %+v", testCase.WantTypes, stmt.Types)
+			}
+			if stmt.Multi() != (len(testCase.WantTypes) > 1) {
+				t.Fatalf("want multi %t, got %t", len(testCase.WantTypes) > 1, stmt.Multi())
+			}
+			if !reflect.DeepEqual(testCase.WantTables, stmt.Tables) {
+				t.Fatalf("want tables %+v, got %+v", testCase.WantTables, stmt.Tables)
+			}
+			if stmt.ReadOnly != testCase.WantReadOnly {
+				t.Fatalf("want read only %t, got %t", testCase.WantReadOnly, stmt.ReadOnly)
+			}
+			if stmt.Locking != testCase.WantLocking {
+				t.Fatalf("want locking %t, got %t", testCase.WantLocking, stmt.Locking)
+			}
+			if stmt.Executable != testCase.WantExecutable {
+				t.Fatalf("want executable %t, got %t", testCase.WantExecutable, stmt.Executable)
+			}
+		})
+	}
+}
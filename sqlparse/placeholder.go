@@ -0,0 +1,136 @@
+package sqlparse
+
+import (
+	"strconv"
+	"strings"
+)
+
+// PlaceholderStyle is the syntax of positional placeholders.
+type PlaceholderStyle int
+
+const (
+	// PlaceholderQuestion is ?, used by SQLite and MySQL.
+	PlaceholderQuestion PlaceholderStyle = iota
+
+	// PlaceholderDollar is $1, used by PostgreSQL.
+	PlaceholderDollar
+
+	// PlaceholderColon is :1, used by Oracle.
+	PlaceholderColon
+
+	// PlaceholderAt is @p1, used by SQL Server.
+	PlaceholderAt
+)
+
+// PlaceholderStyle returns the positional placeholder style of the dialect.
+func (dialect Dialect) PlaceholderStyle() PlaceholderStyle {
+	switch dialect {
+	case DialectPostgres:
+		return PlaceholderDollar
+	case DialectSQLServer:
+		return PlaceholderAt
+	}
+	return PlaceholderQuestion
+}
+
+// Param identifies the argument bound to a placeholder, by name or by ordinal position starting at 1.
+type Param struct {
+	Name string
+
+	Ordinal int
+}
+
+// Rewritten is a query with its placeholders rewritten into a positional style.
+type Rewritten struct {
+	Query string
+
+	// Params are the arguments bound to the positional parameters of Query, in order.
+	Params []Param
+
+	// Named reports whether the original query has named placeholders.
+	Named bool
+}
+
+// RewritePlaceholders rewrites the placeholders of query, tokenized following dialect, into style.
+// Numbered styles bind each distinct parameter once, ? binds every occurrence.
+func RewritePlaceholders(query string, dialect Dialect, style PlaceholderStyle) Rewritten {
+	return rewritePlaceholders(query, tokenize(query, dialect, false), dialect, style)
+}
+
+// RewriteNamedPlaceholders is RewritePlaceholders for queries whose :name and @name placeholders
+// are emulated on top of dialect. MySQL user variables, such as @v, are left as they are.
+func RewriteNamedPlaceholders(query string, dialect Dialect, style PlaceholderStyle) Rewritten {
+	return rewritePlaceholders(query, tokenize(query, dialect, true), dialect, style)
+}
+
+func rewritePlaceholders(query string, tokens []Token, dialect Dialect, style PlaceholderStyle) Rewritten {
+	var rewritten Rewritten
+	var builder strings.Builder
+	builder.Grow(len(query))
+
+	numbers := make(map[Param]int)
+	maxOrdinal := 0
+	for _, token := range tokens {
+		if token.Kind != TokenPlaceholder {
+			builder.WriteString(token.Text)
+			continue
+		}
+
+		param := tokenParam(token, dialect, maxOrdinal)
+		if param.Name != "" {
+			rewritten.Named = true
+		} else if param.Ordinal > maxOrdinal {
+			maxOrdinal = param.Ordinal
+		}
+
+		if style == PlaceholderQuestion {
+			rewritten.Params = append(rewritten.Params, param)
+			builder.WriteByte('?')
+			continue
+		}
+
+		number, ok := numbers[param]
+		if !ok {
+			rewritten.Params = append(rewritten.Params, param)
+			number = len(rewritten.Params)
+			numbers[param] = number
+		}
+		switch style {
+		case PlaceholderDollar:
+			builder.WriteByte('$')
+		case PlaceholderColon:
+			builder.WriteByte(':')
+		case PlaceholderAt:
+			builder.WriteString("@p")
+		}
+		builder.WriteString(strconv.Itoa(number))
+	}
+
+	rewritten.Query = builder.String()
+	return rewritten
+}
+
+// tokenParam returns the parameter of a placeholder token, a bare ? binds the one after maxOrdinal.
+func tokenParam(token Token, dialect Dialect, maxOrdinal int) Param {
+	text := token.Text
+	switch text[0] {
+	case '?':
+		if len(text) == 1 {
+			return Param{Ordinal: maxOrdinal + 1}
+		}
+		ordinal, _ := strconv.Atoi(text[1:])
+		return Param{Ordinal: ordinal}
+	case '$':
+		if ordinal, err := strconv.Atoi(text[1:]); err == nil {
+			return Param{Ordinal: ordinal}
+		}
+	case '@':
+		// go-mssqldb binds @p1 to the first argument
+		if dialect == DialectSQLServer && len(text) > 2 && (text[1] == 'p' || text[1] == 'P') {
+			if ordinal, err := strconv.Atoi(text[2:]); err == nil {
+				return Param{Ordinal: ordinal}
+			}
+		}
+	}
+	return Param{Name: text[1:]}
+}
@@ -0,0 +1,125 @@
+package sqlparse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRewritePlaceholders(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		Query      string
+		Dialect    Dialect
+		Style      PlaceholderStyle
+		WantQuery  string
+		WantParams []Param
+		WantNamed  bool
+	}{
+		{
+			Name:       "test_rewrite_named_to_question",
+			Query:      "SELECT * FROM t WHERE a = :a AND b = @b AND c = :a AND d = ':x'",
+			Style:      PlaceholderQuestion,
+			WantQuery:  "SELECT * FROM t WHERE a = ? AND b = ? AND c = ? AND d = ':x'",
+			WantParams: []Param{{Name: "a"}, {Name: "b"}, {Name: "a"}},
+			WantNamed:  true,
+		},
+		{
+			Name:       "test_rewrite_named_to_dollar",
+			Query:      "SELECT * FROM t WHERE a = :a AND b = @b /* :c */ AND c = :a",
+			Style:      PlaceholderDollar,
+			WantQuery:  "SELECT * FROM t WHERE a = $1 AND b = $2 /* :c */ AND c = $1",
+			WantParams: []Param{{Name: "a"}, {Name: "b"}},
+			WantNamed:  true,
+		},
+		{
+			Name:       "test_rewrite_question_to_dollar",
+			Query:      "SELECT * FROM t WHERE a = ? AND b = ?3 AND c = ?",
+			Dialect:    DialectSQLite,
+			Style:      PlaceholderDollar,
+			WantQuery:  "SELECT * FROM t WHERE a = $1 AND b = $2 AND c = $3",
+			WantParams: []Param{{Ordinal: 1}, {Ordinal: 3}, {Ordinal: 4}},
+		},
+		{
+			Name:       "test_rewrite_dollar_to_question",
+			Query:      "SELECT * FROM t WHERE a = $2 AND b = $1 AND c = $2 AND d = $$?$$",
+			Dialect:    DialectPostgres,
+			Style:      PlaceholderQuestion,
+			WantQuery:  "SELECT * FROM t WHERE a = ? AND b = ? AND c = ? AND d = $$?$$",
+			WantParams: []Param{{Ordinal: 2}, {Ordinal: 1}, {Ordinal: 2}},
+		},
+		{
+			Name:       "test_rewrite_sqlserver_to_at",
+			Query:      "SELECT * FROM t WHERE a = @p2 AND b = @name",
+			Dialect:    DialectSQLServer,
+			Style:      PlaceholderAt,
+			WantQuery:  "SELECT * FROM t WHERE a = @p1 AND b = @p2",
+			WantParams: []Param{{Ordinal: 2}, {Name: "name"}},
+			WantNamed:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			rewritten := RewritePlaceholders(testCase.Query, testCase.Dialect, testCase.Style)
+			if rewritten.Query != testCase.WantQuery {
+				t.Fatalf("want query %s, got %s", testCase.WantQuery, rewritten.Query)
+			}
+			if !reflect.DeepEqual(testCase.WantParams, rewritten.Params) {
+				t.Fatalf("want params %+v, got %+v", testCase.WantParams, rewritten.Params)
+			}
+			if rewritten.Named != testCase.WantNamed {
+				t.Fatalf("want named %t, got %t", testCase.WantNamed, rewritten.Named)
+			}
+		})
+	}
+}
+
+func TestRewriteNamedPlaceholders(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		Query      string
+		Dialect    Dialect
+		WantQuery  string
+		WantParams []Param
+		WantNamed  bool
+	}{
+		{
+			Name:       "test_rewrite_named_postgres",
+			Query:      "SELECT a::int FROM t WHERE a = :a AND b = @b",
+			Dialect:    DialectPostgres,
+			WantQuery:  "SELECT a::int FROM t WHERE a = $1 AND b = $2",
+			WantParams: []Param{{Name: "a"}, {Name: "b"}},
+			WantNamed:  true,
+		},
+		{
+			Name:       "test_rewrite_named_mysql_variable",
+			Query:      "SELECT @rownum := @rownum + 1 FROM t WHERE a = :a",
+			Dialect:    DialectMySQL,
+			WantQuery:  "SELECT @rownum := @rownum + 1 FROM t WHERE a = ?",
+			WantParams: []Param{{Name: "a"}},
+			WantNamed:  true,
+		},
+		{
+			Name:       "test_rewrite_named_sqlserver_native",
+			Query:      "SELECT * FROM t WHERE a = @p1",
+			Dialect:    DialectSQLServer,
+			WantQuery:  "SELECT * FROM t WHERE a = @p1",
+			WantParams: []Param{{Ordinal: 1}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			rewritten := RewriteNamedPlaceholders(testCase.Query, testCase.Dialect, testCase.Dialect.PlaceholderStyle())
+			if rewritten.Query != testCase.WantQuery {
+				t.Fatalf("want query %s, got %s", testCase.WantQuery, rewritten.Query)
+			}
+			if !reflect.DeepEqual(testCase.WantParams, rewritten.Params) {
+				t.Fatalf("want params %+v, got %+v", testCase.WantParams, rewritten.Params)
+			}
+			if rewritten.Named != testCase.WantNamed {
+				t.Fatalf("want named %t, got %t", testCase.WantNamed, rewritten.Named)
+			}
+		})
+	}
+}
@@ -0,0 +1,377 @@
+// Package sqlparse is a lightweight, dialect-aware SQL tokenizer and statement classifier.
+//
+// It does not validate SQL, it only understands enough of the lexical structure
+// (quotes, comments and placeholders) to classify statements and find the referenced tables.
+package sqlparse
+
+import (
+	"strings"
+)
+
+// Dialect selects the lexical rules of a database.
+type Dialect int
+
+const (
+	// DialectGeneric accepts the placeholders and quotes of all dialects.
+	// As in standard SQL, a backslash does not escape a quote, so a string ends at the first lone quote
+	// which ends it in any dialect but MySQL. Queries for MySQL need DialectMySQL.
+	DialectGeneric Dialect = iota
+
+	// DialectSQLite is SQLite: ?, ?NNN, :name, @name and $name placeholders.
+	DialectSQLite
+
+	// DialectPostgres is PostgreSQL: $1 placeholders and dollar-quoted strings.
+	DialectPostgres
+
+	// DialectMySQL is MySQL: ? placeholders, # comments, backslash escapes and double-quoted strings.
+	DialectMySQL
+
+	// DialectSQLServer is SQL Server: @p1 and @name placeholders and [bracketed] identifiers.
+	DialectSQLServer
+)
+
+// String returns the name of the dialect.
+func (dialect Dialect) String() string {
+	switch dialect {
+	case DialectSQLite:
+		return "sqlite"
+	case DialectPostgres:
+		return "postgres"
+	case DialectMySQL:
+		return "mysql"
+	case DialectSQLServer:
+		return "sqlserver"
+	}
+	return "generic"
+}
+
+// TokenKind is the kind of a token.
+type TokenKind int
+
+const (
+	// TokenWhitespace is a run of whitespace.
+	TokenWhitespace TokenKind = iota
+
+	// TokenComment is a -- comment, a /* */ comment or a MySQL # comment.
+	TokenComment
+
+	// TokenWord is a keyword or a unquoted identifier.
+	TokenWord
+
+	// TokenQuotedIdent is a quoted identifier, such as "name", `name` or [name].
+	TokenQuotedIdent
+
+	// TokenString is a string literal, including the quotes and prefix.
+	TokenString
+
+	// TokenNumber is a numeric literal.
+	TokenNumber
+
+	// TokenPlaceholder is a bind parameter, such as ?, ?1, $1, :name or @name.
+	TokenPlaceholder
+
+	// TokenPunct is one of ( ) , ; and .
+	TokenPunct
+
+	// TokenOperator is any other symbol.
+	TokenOperator
+
+	// TokenExecutableComment is the opening /*!, /*M! or /*+ and the closing */ of a comment
+	// which MySQL and MariaDB run as code: executable comments, with the optional version, and optimizer hints.
+	// The text between them is tokenized as code.
+	TokenExecutableComment
+)
+
+// Token is a piece of a query.
+type Token struct {
+	Kind TokenKind
+
+	// Text is the exact text of the token, concatenating the texts of all tokens gives the query back.
+	Text string
+
+	// Pos is the byte offset of the token in the query.
+	Pos int
+}
+
+// Significant reports whether the token is neither whitespace nor comment.
+// The delimiters of executable comments are not significant, their content is.
+func (token Token) Significant() bool {
+	return token.Kind != TokenWhitespace && token.Kind != TokenComment && token.Kind != TokenExecutableComment
+}
+
+// Keyword returns the upper case text of a word token, or "" for other tokens.
+func (token Token) Keyword() string {
+	if token.Kind != TokenWord {
+		return ""
+	}
+	return strings.ToUpper(token.Text)
+}
+
+// Ident returns the identifier of a word or quoted identifier token, without quotes.
+// Unquoted identifiers are folded to lower case.
+func (token Token) Ident() string {
+	switch token.Kind {
+	case TokenWord:
+		return strings.ToLower(token.Text)
+	case TokenQuotedIdent:
+		if len(token.Text) < 2 {
+			return token.Text
+		}
+		quote := token.Text[len(token.Text)-1:]
+		inner := token.Text[1 : len(token.Text)-1]
+		return strings.Replace(inner, quote+quote, quote, -1)
+	}
+	return ""
+}
+
+const operatorChars = "<>=!|&~^%:"
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isWordStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isWordChar(c byte) bool {
+	return isWordStart(c) || isDigit(c) || c == '$'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Tokenize splits query into tokens following the rules of dialect.
+func Tokenize(query string, dialect Dialect) []Token {
+	return tokenize(query, dialect, false)
+}
+
+// tokenize splits query into tokens following the rules of dialect.
+// If named, :name and @name are placeholders in every dialect, except MySQL user variables.
+func tokenize(query string, dialect Dialect, named bool) []Token {
+	var tokens []Token
+	n := len(query)
+	executable := false
+	for pos := 0; pos < n; {
+		start := pos
+		kind := TokenOperator
+		c := query[pos]
+		opening := 0
+		if !executable {
+			opening = executableCommentEnd(query, pos, dialect)
+		}
+		switch {
+		case executable && c == '*' && pos+1 < n && query[pos+1] == '/':
+			pos += 2
+			kind = TokenExecutableComment
+			executable = false
+
+		case opening > 0:
+			pos = opening
+			kind = TokenExecutableComment
+			executable = true
+
+		case isSpace(c):
+			for pos < n && isSpace(query[pos]) {
+				pos++
+			}
+			kind = TokenWhitespace
+
+		case c == '-' && pos+1 < n && query[pos+1] == '-',
+			c == '#' && dialect == DialectMySQL:
+			for pos < n && query[pos] != '\n' {
+				pos++
+			}
+			kind = TokenComment
+
+		case c == '/' && pos+1 < n && query[pos+1] == '*':
+			end := strings.Index(query[pos+2:], "*/")
+			if end < 0 {
+				pos = n
+			} else {
+				pos += 2 + end + 2
+			}
+			kind = TokenComment
+
+		case c == '\'':
+			pos = skipQuoted(query, pos, '\'', dialect == DialectMySQL)
+			kind = TokenString
+
+		case c == '"' && dialect == DialectMySQL:
+			pos = skipQuoted(query, pos, '"', true)
+			kind = TokenString
+
+		case c == '"',
+			c == '`' && dialect != DialectPostgres && dialect != DialectSQLServer:
+			pos = skipQuoted(query, pos, c, false)
+			kind = TokenQuotedIdent
+
+		case c == '[' && (dialect == DialectSQLServer || dialect == DialectSQLite):
+			pos = skipQuoted(query, pos, ']', false)
+			kind = TokenQuotedIdent
+
+		case isDigit(c) || c == '.' && pos+1 < n && isDigit(query[pos+1]):
+			pos = skipNumber(query, pos)
+			kind = TokenNumber
+
+		case c == '?' && dialect != DialectPostgres:
+			for pos++; pos < n && isDigit(query[pos]); pos++ {
+			}
+			kind = TokenPlaceholder
+
+		case c == '$' && pos+1 < n && isDigit(query[pos+1]) && dialect != DialectMySQL && dialect != DialectSQLServer:
+			for pos++; pos < n && isDigit(query[pos]); pos++ {
+			}
+			kind = TokenPlaceholder
+
+		case c == '$' && pos+1 < n && (query[pos+1] == '$' || isWordStart(query[pos+1])) && (dialect == DialectPostgres || dialect == DialectGeneric):
+			if end, ok := skipDollarQuoted(query, pos); ok {
+				pos = end
+				kind = TokenString
+			} else if dialect == DialectGeneric && isWordStart(query[pos+1]) {
+				pos = skipWord(query, pos+1)
+				kind = TokenPlaceholder
+			} else {
+				pos++
+			}
+
+		case c == '$' && pos+1 < n && isWordStart(query[pos+1]) && dialect == DialectSQLite:
+			pos = skipWord(query, pos+1)
+			kind = TokenPlaceholder
+
+		case c == '@' && pos+1 < n && query[pos+1] == '@':
+			// system variables, such as @@version
+			pos = skipWord(query, pos+2)
+			kind = TokenWord
+
+		case c == '@' && pos+1 < n && isWordStart(query[pos+1]) && dialect == DialectMySQL:
+			// user variables
+			pos = skipWord(query, pos+1)
+			kind = TokenWord
+
+		case c == '@' && pos+1 < n && isWordStart(query[pos+1]) && (dialect != DialectPostgres || named),
+			c == ':' && pos+1 < n && isWordStart(query[pos+1]) && (dialect == DialectGeneric || dialect == DialectSQLite || named):
+			pos = skipWord(query, pos+1)
+			kind = TokenPlaceholder
+
+		case isWordStart(c):
+			pos = skipWord(query, pos)
+			kind = TokenWord
+			// prefixed strings, such as E'', N'', X'' and B''
+			if pos-start == 1 && pos < n && query[pos] == '\'' && strings.IndexByte("eEnNxXbB", c) >= 0 {
+				pos = skipQuoted(query, pos, '\'', c == 'e' || c == 'E' || dialect == DialectMySQL)
+				kind = TokenString
+			}
+
+		case c == '(' || c == ')' || c == ',' || c == ';' || c == '.':
+			pos++
+			kind = TokenPunct
+
+		case strings.IndexByte(operatorChars, c) >= 0:
+			for pos < n && strings.IndexByte(operatorChars, query[pos]) >= 0 {
+				pos++
+			}
+
+		default:
+			pos++
+		}
+		tokens = append(tokens, Token{Kind: kind, Text: query[start:pos], Pos: start})
+	}
+	return tokens
+}
+
+// executableCommentEnd returns the end of the opening of a executable comment at pos,
+// such as /*!50700 or /*+, or 0 if there is none. Only MySQL and the generic dialect have them.
+func executableCommentEnd(query string, pos int, dialect Dialect) int {
+	if dialect != DialectMySQL && dialect != DialectGeneric || !strings.HasPrefix(query[pos:], "/*") {
+		return 0
+	}
+	switch {
+	case strings.HasPrefix(query[pos+2:], "+"):
+		return pos + 3
+	case strings.HasPrefix(query[pos+2:], "!"):
+		pos += 3
+	case strings.HasPrefix(query[pos+2:], "M!"):
+		pos += 4
+	default:
+		return 0
+	}
+	// the minimum server version
+	for pos < len(query) && isDigit(query[pos]) {
+		pos++
+	}
+	return pos
+}
+
+func skipWord(query string, pos int) int {
+	for pos < len(query) && isWordChar(query[pos]) {
+		pos++
+	}
+	return pos
+}
+
+// skipQuoted returns the position after the quoted text starting at pos.
+// A doubled quote is a escaped quote, backslash escapes the next byte if allowed.
+func skipQuoted(query string, pos int, quote byte, backslash bool) int {
+	for pos++; pos < len(query); pos++ {
+		switch query[pos] {
+		case '\\':
+			if backslash {
+				pos++
+			}
+		case quote:
+			if pos+1 < len(query) && query[pos+1] == quote {
+				pos++
+				continue
+			}
+			return pos + 1
+		}
+	}
+	return len(query)
+}
+
+func skipNumber(query string, pos int) int {
+	n := len(query)
+	if query[pos] == '0' && pos+1 < n && (query[pos+1] == 'x' || query[pos+1] == 'X') {
+		pos += 2
+		for pos < n && strings.IndexByte("0123456789abcdefABCDEF", query[pos]) >= 0 {
+			pos++
+		}
+		return pos
+	}
+	for pos < n && (isDigit(query[pos]) || query[pos] == '.') {
+		pos++
+	}
+	if pos < n && (query[pos] == 'e' || query[pos] == 'E') {
+		next := pos + 1
+		if next < n && (query[next] == '+' || query[next] == '-') {
+			next++
+		}
+		if next < n && isDigit(query[next]) {
+			for pos = next; pos < n && isDigit(query[pos]); pos++ {
+			}
+		}
+	}
+	return pos
+}
+
+// skipDollarQuoted skips a PostgreSQL dollar-quoted string, such as $$text$$ or $tag$text$tag$.
+func skipDollarQuoted(query string, pos int) (int, bool) {
+	end := pos + 1
+	for end < len(query) && query[end] != '$' {
+		if !isWordChar(query[end]) {
+			return pos, false
+		}
+		end++
+	}
+	if end >= len(query) {
+		return pos, false
+	}
+	tag := query[pos : end+1]
+	closing := strings.Index(query[end+1:], tag)
+	if closing < 0 {
+		return len(query), true
+	}
+	return end + 1 + closing + len(tag), true
+}
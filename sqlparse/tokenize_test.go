@@ -0,0 +1,112 @@
+package sqlparse
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	testCases := []struct {
+		Name             string
+		Dialect          Dialect
+		Query            string
+		WantPlaceholders []string
+		WantStrings      []string
+	}{
+		{
+			Name:             "test_tokenize_generic",
+			Dialect:          DialectGeneric,
+			Query:            "SELECT * FROM t WHERE a = ? AND b = $1 AND c = :name AND d = @p1 AND e = 'x?' -- :ignored\n",
+			WantPlaceholders: []string{"?", "$1", ":name", "@p1"},
+			WantStrings:      []string{"'x?'"},
+		},
+		{
+			Name:        "test_tokenize_generic_backslash",
+			Dialect:     DialectGeneric,
+			Query:       "SELECT * FROM t WHERE name = 'x\\' OR 1=1 --'",
+			WantStrings: []string{"'x\\'"},
+		},
+		{
+			Name:             "test_tokenize_sqlite",
+			Dialect:          DialectSQLite,
+			Query:            "SELECT [a?], `b`, \"c\" FROM t WHERE a = ?1 AND b = $name AND c = :c",
+			WantPlaceholders: []string{"?1", "$name", ":c"},
+		},
+		{
+			Name:             "test_tokenize_postgres",
+			Dialect:          DialectPostgres,
+			Query:            "SELECT a::int, b ? 'k', $$ $1 $$, E'\\'' FROM t WHERE a = $1 AND b = $2",
+			WantPlaceholders: []string{"$1", "$2"},
+			WantStrings:      []string{"'k'", "$$ $1 $$", "E'\\''"},
+		},
+		{
+			Name:             "test_tokenize_mysql",
+			Dialect:          DialectMySQL,
+			Query:            "SELECT @v, \"it\\\"s ?\" FROM t WHERE a = ? # and b = ?",
+			WantPlaceholders: []string{"?"},
+			WantStrings:      []string{`"it\"s ?"`},
+		},
+		{
+			Name:             "test_tokenize_mysql_executable_comment",
+			Dialect:          DialectMySQL,
+			Query:            "SELECT a /*!50000 , ? */ /*M! , 'b' */ /*+ BKA(t) */ FROM t /* ? */",
+			WantPlaceholders: []string{"?"},
+			WantStrings:      []string{"'b'"},
+		},
+		{
+			Name:    "test_tokenize_postgres_hint",
+			Dialect: DialectPostgres,
+			Query:   "SELECT /*+ SeqScan(t) */ a FROM t /*! $1 */",
+		},
+		{
+			Name:             "test_tokenize_sqlserver",
+			Dialect:          DialectSQLServer,
+			Query:            "SELECT [name] FROM t WHERE a = @p1 AND b = @p2 AND c = @@ROWCOUNT",
+			WantPlaceholders: []string{"@p1", "@p2"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			tokens := Tokenize(testCase.Query, testCase.Dialect)
+
+			var texts, placeholders, strs []string
+			for _, token := range tokens {
+				texts = append(texts, token.Text)
+				switch token.Kind {
+				case TokenPlaceholder:
+					placeholders = append(placeholders, token.Text)
+				case TokenString:
+					strs = append(strs, token.Text)
+				}
+				if testCase.Query[token.Pos:token.Pos+len(token.Text)] != token.Text {
+					t.Fatalf("token %q is not at %d", token.Text, token.Pos)
+				}
+			}
+			if got := strings.Join(texts, ""); got != testCase.Query {
+				t.Fatalf("want tokens joined to %s, got %s", testCase.Query, got)
+			}
+			if !reflect.DeepEqual(testCase.WantPlaceholders, placeholders) {
+				t.Fatalf("want placeholders %+v, got %+v", testCase.WantPlaceholders, placeholders)
+			}
+			if !reflect.DeepEqual(testCase.WantStrings, strs) {
+				t.Fatalf("want strings %+v, got %+v", testCase.WantStrings, strs)
+			}
+		})
+	}
+}
+
+func TestToken_Ident(t *testing.T) {
+	tokens := Tokenize(`Users "Order""s" [x]`, DialectSQLite)
+	var idents []string
+	for _, token := range tokens {
+		if token.Significant() {
+			idents = append(idents, token.Ident())
+		}
+	}
+	want := []string{"users", `Order"s`, "x"}
+	if !reflect.DeepEqual(want, idents) {
+		t.Fatalf("want idents %+v, got %+v", want, idents)
+	}
+}
@@ -0,0 +1,160 @@
+package middledriver
+
+import (
+	"database/sql/driver"
+	"expvar"
+	"sync/atomic"
+)
+
+// Stats holds live counters of the wrapped connections, statements and transactions.
+// A nil *Stats is valid and counts nothing.
+type Stats struct {
+	openConns int64
+	inFlight  int64
+	openStmts int64
+	openTxs   int64
+	errors    int64
+	timeouts  int64
+
+	totalConns   int64
+	totalQueries int64
+	totalExecs   int64
+	totalStmts   int64
+	totalTxs     int64
+}
+
+// StatsSnapshot is a point-in-time copy of Stats.
+type StatsSnapshot struct {
+	OpenConns int64
+	InFlight  int64
+	OpenStmts int64
+	OpenTxs   int64
+	Errors    int64
+
+	// Timeouts are the errors which are timeouts, see IsTimeout.
+	Timeouts int64
+
+	TotalConns   int64
+	TotalQueries int64
+	TotalExecs   int64
+	TotalStmts   int64
+	TotalTxs     int64
+}
+
+// NewStats create a empty Stats.
+func NewStats() *Stats {
+	return &Stats{}
+}
+
+// Snapshot returns the current values of the counters.
+func (stats *Stats) Snapshot() StatsSnapshot {
+	if stats == nil {
+		return StatsSnapshot{}
+	}
+	return StatsSnapshot{
+		OpenConns:    atomic.LoadInt64(&stats.openConns),
+		InFlight:     atomic.LoadInt64(&stats.inFlight),
+		OpenStmts:    atomic.LoadInt64(&stats.openStmts),
+		OpenTxs:      atomic.LoadInt64(&stats.openTxs),
+		Errors:       atomic.LoadInt64(&stats.errors),
+		Timeouts:     atomic.LoadInt64(&stats.timeouts),
+		TotalConns:   atomic.LoadInt64(&stats.totalConns),
+		TotalQueries: atomic.LoadInt64(&stats.totalQueries),
+		TotalExecs:   atomic.LoadInt64(&stats.totalExecs),
+		TotalStmts:   atomic.LoadInt64(&stats.totalStmts),
+		TotalTxs:     atomic.LoadInt64(&stats.totalTxs),
+	}
+}
+
+// Var returns a expvar.Var which reports the snapshot of stats as JSON.
+func (stats *Stats) Var() expvar.Var {
+	return expvar.Func(func() interface{} {
+		return stats.Snapshot()
+	})
+}
+
+// Publish publishes stats to expvar with the given name.
+// Like expvar.Publish, it panics if the name is already registered.
+func (stats *Stats) Publish(name string) {
+	expvar.Publish(name, stats.Var())
+}
+
+func (stats *Stats) connOpened() {
+	if stats == nil {
+		return
+	}
+	atomic.AddInt64(&stats.openConns, 1)
+	atomic.AddInt64(&stats.totalConns, 1)
+}
+
+func (stats *Stats) connClosed() {
+	if stats == nil {
+		return
+	}
+	atomic.AddInt64(&stats.openConns, -1)
+}
+
+func (stats *Stats) stmtOpened() {
+	if stats == nil {
+		return
+	}
+	atomic.AddInt64(&stats.openStmts, 1)
+	atomic.AddInt64(&stats.totalStmts, 1)
+}
+
+func (stats *Stats) stmtClosed() {
+	if stats == nil {
+		return
+	}
+	atomic.AddInt64(&stats.openStmts, -1)
+}
+
+func (stats *Stats) txBegan() {
+	if stats == nil {
+		return
+	}
+	atomic.AddInt64(&stats.openTxs, 1)
+	atomic.AddInt64(&stats.totalTxs, 1)
+}
+
+func (stats *Stats) txEnded() {
+	if stats == nil {
+		return
+	}
+	atomic.AddInt64(&stats.openTxs, -1)
+}
+
+func (stats *Stats) queryStarted() {
+	if stats == nil {
+		return
+	}
+	atomic.AddInt64(&stats.inFlight, 1)
+	atomic.AddInt64(&stats.totalQueries, 1)
+}
+
+func (stats *Stats) execStarted() {
+	if stats == nil {
+		return
+	}
+	atomic.AddInt64(&stats.inFlight, 1)
+	atomic.AddInt64(&stats.totalExecs, 1)
+}
+
+func (stats *Stats) finished(err error) {
+	if stats == nil {
+		return
+	}
+	atomic.AddInt64(&stats.inFlight, -1)
+	stats.failed(err)
+}
+
+// failed counts err, driver.ErrSkip is not a error but a signal to database/sql.
+func (stats *Stats) failed(err error) {
+	if stats == nil || err == nil || err == driver.ErrSkip {
+		return
+	}
+	atomic.AddInt64(&stats.errors, 1)
+	if IsTimeout(err) {
+		atomic.AddInt64(&stats.timeouts, 1)
+	}
+}
@@ -0,0 +1,152 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"testing"
+
+	"github.com/wencan/middledriver/internal/fakedriver"
+)
+
+func TestStats(t *testing.T) {
+	stats := NewStats()
+	dri := Driver{
+		Target: fakedriver.FakeDriver{
+			ExpectedQueryContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
+				if s := stats.Snapshot(); s.InFlight != 1 {
+					return nil, errors.New("want 1 in-flight query")
+				}
+				return &fakedriver.FakeRows{ColumnNames: []string{"1"}}, nil
+			},
+			ExpectedExecContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+				return nil, errors.New("test")
+			},
+		},
+		Stats: stats,
+	}
+	sql.Register("test_stats", dri)
+
+	db, err := sql.Open("test_stats", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := db.Conn(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := conn.QueryContext(context.TODO(), "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	_, err = conn.ExecContext(context.TODO(), "DELETE FROM users")
+	if err == nil {
+		t.Fatal("want error, got nil")
+	}
+	stmt, err := conn.PrepareContext(context.TODO(), "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := conn.BeginTx(context.TODO(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := StatsSnapshot{
+		OpenConns:    1,
+		OpenStmts:    1,
+		OpenTxs:      1,
+		Errors:       1,
+		TotalConns:   1,
+		TotalQueries: 1,
+		TotalExecs:   1,
+		TotalStmts:   1,
+		TotalTxs:     1,
+	}
+	if got := stats.Snapshot(); got != want {
+		t.Fatalf("want stats %+v, got %+v", want, got)
+	}
+
+	tx.Commit()
+	stmt.Close()
+	conn.Close()
+	db.Close()
+
+	want.OpenConns, want.OpenStmts, want.OpenTxs = 0, 0, 0
+	if got := stats.Snapshot(); got != want {
+		t.Fatalf("want stats %+v, got %+v", want, got)
+	}
+}
+
+func TestStats_PrepareOnly(t *testing.T) {
+	stats := NewStats()
+	dri := Driver{
+		Target: fakedriver.FakeDriver{
+			ExpectedQueryContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
+				return &fakedriver.FakeRows{ColumnNames: []string{"1"}}, nil
+			},
+			ExpectedExecContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+				return nil, errors.New("test")
+			},
+			PrepareOnly: true,
+		},
+		Stats: stats,
+	}
+	sql.Register("test_stats_prepare_only", dri)
+
+	db, err := sql.Open("test_stats_prepare_only", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(context.TODO(), "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	_, err = db.ExecContext(context.TODO(), "DELETE FROM users")
+	if err == nil {
+		t.Fatal("want error, got nil")
+	}
+
+	want := StatsSnapshot{
+		OpenConns:    1,
+		Errors:       1,
+		TotalConns:   1,
+		TotalQueries: 1,
+		TotalExecs:   1,
+	}
+	if got := stats.Snapshot(); got != want {
+		t.Fatalf("want stats %+v, got %+v", want, got)
+	}
+}
+
+func TestStats_Publish(t *testing.T) {
+	stats := NewStats()
+	stats.connOpened()
+	stats.Publish("test_stats_publish")
+
+	var got StatsSnapshot
+	err := json.Unmarshal([]byte(expvar.Get("test_stats_publish").String()), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.OpenConns != 1 {
+		t.Fatalf("want OpenConns 1, got %d", got.OpenConns)
+	}
+}
+
+func TestStats_Nil(t *testing.T) {
+	var stats *Stats
+	stats.connOpened()
+	stats.finished(errors.New("test"))
+	if got := stats.Snapshot(); got != (StatsSnapshot{}) {
+		t.Fatalf("want empty stats, got %+v", got)
+	}
+}
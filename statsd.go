@@ -0,0 +1,190 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StatsdOptions configures a StatsdEmitter.
+type StatsdOptions struct {
+	// Prefix is prepended to every metric name, for example "myapp.sql.".
+	Prefix string
+
+	// Tags are attached to every metric, in the form "key:value", with DogStatsD only.
+	Tags []string
+
+	// DogStatsD enables the DogStatsD tag extension, the operation, outcome and query fingerprint are sent as tags.
+	// Without it, neither Tags nor the fingerprint are sent, as plain StatsD has no tags
+	// and a fingerprint per metric name would make too many metrics;
+	// the operation and outcome are part of the metric name.
+	DogStatsD bool
+
+	// FlushInterval is the maximum time a metric waits in the buffer, default 1 second.
+	FlushInterval time.Duration
+
+	// MaxPacketSize is the maximum size of a UDP packet, default 1432 bytes.
+	MaxPacketSize int
+
+	// QueueSize is the number of metrics buffered before new ones are dropped, default 4096.
+	QueueSize int
+}
+
+// StatsdEmitter sends a timing and a counter of every operation to a StatsD server over UDP.
+// Metrics are batched into packets by a background goroutine, the query path never blocks on the network.
+type StatsdEmitter struct {
+	options StatsdOptions
+
+	conn net.Conn
+
+	queue chan string
+
+	dropped int64
+
+	// closed is set by Close under the write lock, the queue is sent to under the read lock.
+	mu     sync.RWMutex
+	closed bool
+
+	done chan struct{}
+}
+
+// NewStatsdEmitter create a StatsdEmitter sending to the StatsD server at addr.
+func NewStatsdEmitter(addr string, options StatsdOptions) (*StatsdEmitter, error) {
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = time.Second
+	}
+	if options.MaxPacketSize <= 0 {
+		options.MaxPacketSize = 1432
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = 4096
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	emitter := &StatsdEmitter{
+		options: options,
+		conn:    conn,
+		queue:   make(chan string, options.QueueSize),
+		done:    make(chan struct{}),
+	}
+	go emitter.loop()
+	return emitter, nil
+}
+
+// MiddlewareGroup returns the middlewares which emit metrics.
+func (emitter *StatsdEmitter) MiddlewareGroup() MiddlewareGroup {
+	return aroundMiddlewareGroup(func(ctx context.Context, op Operation, query string, namedArg []driver.NamedValue, next func(ctx context.Context) error) error {
+		start := time.Now()
+		err := next(ctx)
+		info, _ := OperationInfoFromContext(ctx)
+		info.Query = originalQuery(ctx, query)
+		emitter.emit(op, info, time.Since(start), err)
+		return err
+	})
+}
+
+// Dropped returns the number of metrics dropped because the queue was full or the emitter was closed.
+func (emitter *StatsdEmitter) Dropped() int64 {
+	return atomic.LoadInt64(&emitter.dropped)
+}
+
+// Close flushes the buffered metrics and closes the connection.
+// The metrics emitted after Close are dropped.
+func (emitter *StatsdEmitter) Close() error {
+	emitter.mu.Lock()
+	if emitter.closed {
+		emitter.mu.Unlock()
+		<-emitter.done
+		return nil
+	}
+	emitter.closed = true
+	close(emitter.queue)
+	emitter.mu.Unlock()
+
+	<-emitter.done
+	return emitter.conn.Close()
+}
+
+func (emitter *StatsdEmitter) emit(op Operation, info OperationInfo, elapsed time.Duration, err error) {
+	outcome := "ok"
+	if IsTimeout(err) {
+		outcome = "timeout"
+	} else if err != nil && err != driver.ErrSkip {
+		outcome = "error"
+	}
+
+	var name, tags string
+	if emitter.options.DogStatsD {
+		name = emitter.options.Prefix + "sql"
+		tags = "|#" + strings.Join(append([]string{
+			"operation:" + string(op),
+			"outcome:" + outcome,
+			"fingerprint:" + info.Fingerprint().String(),
+		}, emitter.options.Tags...), ",")
+	} else {
+		name = emitter.options.Prefix + "sql." + string(op) + "." + outcome
+	}
+
+	millis := strconv.FormatFloat(float64(elapsed)/float64(time.Millisecond), 'f', 3, 64)
+	emitter.send(name + ".duration:" + millis + "|ms" + tags)
+	emitter.send(name + ".count:1|c" + tags)
+}
+
+func (emitter *StatsdEmitter) send(metric string) {
+	emitter.mu.RLock()
+	defer emitter.mu.RUnlock()
+	if emitter.closed {
+		atomic.AddInt64(&emitter.dropped, 1)
+		return
+	}
+
+	select {
+	case emitter.queue <- metric:
+	default:
+		atomic.AddInt64(&emitter.dropped, 1)
+	}
+}
+
+func (emitter *StatsdEmitter) loop() {
+	defer close(emitter.done)
+
+	ticker := time.NewTicker(emitter.options.FlushInterval)
+	defer ticker.Stop()
+
+	buf := make([]byte, 0, emitter.options.MaxPacketSize)
+	flush := func() {
+		if len(buf) > 0 {
+			// UDP is lossy anyway, errors are ignored.
+			emitter.conn.Write(buf)
+			buf = buf[:0]
+		}
+	}
+
+	for {
+		select {
+		case metric, ok := <-emitter.queue:
+			if !ok {
+				flush()
+				return
+			}
+			if len(buf) > 0 && len(buf)+1+len(metric) > emitter.options.MaxPacketSize {
+				flush()
+			}
+			if len(buf) > 0 {
+				buf = append(buf, '\n')
+			}
+			buf = append(buf, metric...)
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
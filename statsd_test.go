@@ -0,0 +1,143 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wencan/middledriver/fingerprint"
+	"github.com/wencan/middledriver/internal/fakedriver"
+)
+
+func TestStatsdEmitter(t *testing.T) {
+	testCases := []struct {
+		Name        string
+		DriverName  string
+		Options     StatsdOptions
+		Exec        bool
+		ReplyError  error
+		WantMetrics []string
+	}{
+		{
+			Name:       "test_statsd_plain",
+			DriverName: "test_statsd_plain",
+			Options:    StatsdOptions{Prefix: "app."},
+			WantMetrics: []string{
+				"app.sql.query.ok.duration:",
+				"app.sql.query.ok.count:1|c",
+			},
+		},
+		{
+			Name:       "test_statsd_dogstatsd_error",
+			DriverName: "test_statsd_dogstatsd_error",
+			Options:    StatsdOptions{DogStatsD: true, Tags: []string{"env:test"}},
+			Exec:       true,
+			ReplyError: errors.New("test"),
+			WantMetrics: []string{
+				"sql.duration:",
+				"sql.count:1|c|#operation:exec,outcome:error,fingerprint:" + fingerprint.Of("SELECT 1").String() + ",env:test",
+			},
+		},
+		{
+			Name:       "test_statsd_plain_timeout",
+			DriverName: "test_statsd_plain_timeout",
+			Exec:       true,
+			ReplyError: &TimeoutError{Timeout: time.Second, Err: errors.New("test")},
+			WantMetrics: []string{
+				"sql.exec.timeout.duration:",
+				"sql.exec.timeout.count:1|c",
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+
+			emitter, err := NewStatsdEmitter(listener.LocalAddr().String(), testCase.Options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dri := Driver{
+				Target: fakedriver.FakeDriver{
+					ExpectedQueryContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
+						return &fakedriver.FakeRows{ColumnNames: []string{"1"}}, testCase.ReplyError
+					},
+					ExpectedExecContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+						return fakedriver.FakeResult{}, testCase.ReplyError
+					},
+				},
+				MiddlewareGroup: emitter.MiddlewareGroup(),
+			}
+			sql.Register(testCase.DriverName, dri)
+
+			db, err := sql.Open(testCase.DriverName, "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if testCase.Exec {
+				db.ExecContext(context.TODO(), "SELECT 1")
+			} else {
+				rows, err := db.QueryContext(context.TODO(), "SELECT 1")
+				if err != nil {
+					t.Fatal(err)
+				}
+				rows.Close()
+			}
+			emitter.Close()
+
+			buf := make([]byte, 2048)
+			listener.SetReadDeadline(time.Now().Add(time.Second))
+			n, _, err := listener.ReadFrom(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			metrics := strings.Split(string(buf[:n]), "\n")
+			if len(metrics) != len(testCase.WantMetrics) {
+				t.Fatalf("want metrics %+v, got %+v", testCase.WantMetrics, metrics)
+			}
+			for idx, want := range testCase.WantMetrics {
+				if !strings.HasPrefix(metrics[idx], want) {
+					t.Fatalf("want metric %s, got %s", want, metrics[idx])
+				}
+			}
+		})
+	}
+}
+
+func TestStatsdEmitterClosed(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	emitter, err := NewStatsdEmitter(listener.LocalAddr().String(), StatsdOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = emitter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emitter.emit(OperationQuery, OperationInfo{Query: "SELECT 1"}, time.Millisecond, nil)
+	if dropped := emitter.Dropped(); dropped != 2 {
+		t.Fatalf("want 2 metrics dropped after Close, got %d", dropped)
+	}
+	err = emitter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql/driver"
 	"errors"
+
+	"github.com/wencan/middledriver/sqlparse"
 )
 
 // Stmt is a prepared statement.
@@ -18,6 +20,9 @@ type Stmt struct {
 
 	query string
 
+	// rewritten reports whether the middlewares changed more than the comments of query before the target prepared it
+	rewritten bool
+
 	queryContextFunc StmtQueryContextFunc
 
 	execContextFunc StmtExecContextFunc
@@ -30,7 +35,16 @@ func newStmt(target driver.Stmt, conn Conn, query string, newStmtQueryContextMid
 		query:  query,
 	}
 
+	inner := conn.driver.innerMiddlewareGroup()
+
 	stmt.queryContextFunc = stmt.generateQueryContextFunc()
+	if inner.NewStmtQueryContextMiddleware != nil {
+		queryContextMiddleware, err := inner.NewStmtQueryContextMiddleware(query)
+		if err != nil {
+			return Stmt{}, err
+		}
+		stmt.queryContextFunc = queryContextMiddleware(stmt.queryContextFunc)
+	}
 	if newStmtQueryContextMiddleware != nil {
 		queryContextMiddleware, err := newStmtQueryContextMiddleware(query)
 		if err != nil {
@@ -40,6 +54,13 @@ func newStmt(target driver.Stmt, conn Conn, query string, newStmtQueryContextMid
 	}
 
 	stmt.execContextFunc = stmt.generateExecContextFunc()
+	if inner.NewStmtExecContextMiddleware != nil {
+		execContextMiddleware, err := inner.NewStmtExecContextMiddleware(query)
+		if err != nil {
+			return Stmt{}, err
+		}
+		stmt.execContextFunc = execContextMiddleware(stmt.execContextFunc)
+	}
 	if newStmtExecContextMiddleware != nil {
 		execContextMiddleware, err := newStmtExecContextMiddleware(query)
 		if err != nil {
@@ -48,19 +69,51 @@ func newStmt(target driver.Stmt, conn Conn, query string, newStmtQueryContextMid
 		stmt.execContextFunc = execContextMiddleware(stmt.execContextFunc)
 	}
 
+	conn.driver.Stats.stmtOpened()
+
 	return stmt, nil
 }
 
 // Close implements Stmt.
 func (stmt Stmt) Close() error {
+	stmt.conn.driver.Stats.stmtClosed()
 	return stmt.target.Close()
 }
 
 // NumInput implements Stmt.
+// If the middlewares rewrote the query before the target prepared it, such as its placeholders,
+// the number of arguments is unknown.
 func (stmt Stmt) NumInput() int {
+	if stmt.rewritten {
+		return -1
+	}
 	return stmt.target.NumInput()
 }
 
+// sameTokens reports whether a and b differ in whitespace and comments only.
+func sameTokens(a, b string, dialect sqlparse.Dialect) bool {
+	var aTokens, bTokens []string
+	for _, token := range sqlparse.Tokenize(a, dialect) {
+		if token.Significant() {
+			aTokens = append(aTokens, token.Text)
+		}
+	}
+	for _, token := range sqlparse.Tokenize(b, dialect) {
+		if token.Significant() {
+			bTokens = append(bTokens, token.Text)
+		}
+	}
+	if len(aTokens) != len(bTokens) {
+		return false
+	}
+	for idx := range aTokens {
+		if aTokens[idx] != bTokens[idx] {
+			return false
+		}
+	}
+	return true
+}
+
 // Query implements Stmt.
 func (stmt Stmt) Query(args []driver.Value) (driver.Rows, error) {
 	return nil, errors.New("Please update Go to 1.8+ version")
@@ -79,7 +132,10 @@ func (stmt Stmt) generateQueryContextFunc() StmtQueryContextFunc {
 
 // QueryContext implements StmtQueryContext.
 func (stmt Stmt) QueryContext(ctx context.Context, namedArg []driver.NamedValue) (driver.Rows, error) {
-	return stmt.queryContextFunc(ctx, namedArg)
+	stmt.conn.driver.Stats.queryStarted()
+	rows, err := stmt.queryContextFunc(withOperationInfo(ctx, stmt.conn.operationInfo(OperationStmtQuery, stmt.query)), namedArg)
+	stmt.conn.finished(stmt.query, err)
+	return rows, err
 }
 
 // Exec implements Stmt.
@@ -100,7 +156,10 @@ func (stmt Stmt) generateExecContextFunc() StmtExecContextFunc {
 
 // ExecContext implements StmtExecContext.
 func (stmt Stmt) ExecContext(ctx context.Context, namedArg []driver.NamedValue) (driver.Result, error) {
-	return stmt.execContextFunc(ctx, namedArg)
+	stmt.conn.driver.Stats.execStarted()
+	result, err := stmt.execContextFunc(withOperationInfo(ctx, stmt.conn.operationInfo(OperationStmtExec, stmt.query)), namedArg)
+	stmt.conn.finished(stmt.query, err)
+	return result, err
 }
 
 type defaultNamedValueChecker struct {
@@ -7,9 +7,11 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/wencan/middledriver/internal/fakedriver"
+	"github.com/wencan/middledriver/sqlparse"
 )
 
 func TestStmt_QueryContext(t *testing.T) {
@@ -656,3 +658,62 @@ func TestStmt_ExecContext(t *testing.T) {
 		})
 	}
 }
+
+func TestStmt_NumInput(t *testing.T) {
+	testCases := []struct {
+		Name            string
+		MiddlewareGroup MiddlewareGroup
+		Query           string
+		WantNumInput    int
+	}{
+		{
+			Name:         "test_stmt_num_input",
+			Query:        "SELECT * FROM users WHERE id = ? AND age > ?",
+			WantNumInput: 2,
+		},
+		{
+			Name:            "test_stmt_num_input_commented",
+			MiddlewareGroup: SQLCommenterMiddlewareGroup(SQLCommenterOptions{Application: "billing"}),
+			Query:           "SELECT * FROM users WHERE id = ? AND age > ?",
+			WantNumInput:    2,
+		},
+		{
+			Name:            "test_stmt_num_input_rewritten",
+			MiddlewareGroup: PlaceholderMiddlewareGroup(sqlparse.DialectPostgres),
+			Query:           "SELECT * FROM users WHERE id = $1 OR parent = $1",
+			WantNumInput:    -1,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			dri := Driver{
+				Target: fakedriver.FakeDriver{
+					ExpectedNumInput: func(query string) int {
+						return strings.Count(query, "?")
+					},
+				},
+				Dialect:         sqlparse.DialectSQLite,
+				MiddlewareGroup: testCase.MiddlewareGroup,
+			}
+			connector, err := dri.OpenConnector("foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, err := connector.Connect(context.TODO())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			stmt, err := conn.(driver.ConnPrepareContext).PrepareContext(context.TODO(), testCase.Query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stmt.Close()
+			if got := stmt.NumInput(); got != testCase.WantNumInput {
+				t.Fatalf("want NumInput %d, got %d", testCase.WantNumInput, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"time"
+)
+
+// TimeoutError is returned by the middlewares of TimeoutMiddlewareGroup when a call fails after its deadline.
+type TimeoutError struct {
+	Timeout time.Duration
+
+	// Err is the error returned by the target.
+	Err error
+}
+
+// Error implements error.
+func (err *TimeoutError) Error() string {
+	return "timeout after " + err.Timeout.String() + ": " + err.Err.Error()
+}
+
+// Unwrap returns the error returned by the target.
+func (err *TimeoutError) Unwrap() error {
+	return err.Err
+}
+
+// Is reports TimeoutError as context.DeadlineExceeded.
+func (err *TimeoutError) Is(target error) bool {
+	return target == context.DeadlineExceeded
+}
+
+// IsTimeout reports whether err is a deadline exceeded or a network timeout.
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// TimeoutOptions configures TimeoutMiddlewareGroup.
+type TimeoutOptions struct {
+	// Default is the timeout of the calls, zero is none.
+	Default time.Duration
+
+	// Fingerprints overrides Default by the fingerprint of the query, as returned by Fingerprint.String.
+	// A zero override disables the timeout of the query.
+	Fingerprints map[string]time.Duration
+}
+
+func (options TimeoutOptions) timeout(ctx context.Context, query string) time.Duration {
+	if len(options.Fingerprints) > 0 {
+		info, _ := OperationInfoFromContext(ctx)
+		info.Query = originalQuery(ctx, query)
+		timeout, ok := options.Fingerprints[info.Fingerprint().String()]
+		if ok {
+			return timeout
+		}
+	}
+	return options.Default
+}
+
+// TimeoutMiddlewareGroup creates a MiddlewareGroup which applies a timeout to queries and executes whose context has no deadline.
+// The rows of a query are bound to the deadline until they are closed.
+// Errors after the deadline are returned as *TimeoutError.
+func TimeoutMiddlewareGroup(options TimeoutOptions) MiddlewareGroup {
+	return MiddlewareGroup{
+		QueryContextMiddleware: func(next QueryContextFunc) QueryContextFunc {
+			return func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
+				ctx, cancel, timeout := withTimeout(ctx, options.timeout(ctx, query))
+				rows, err := next(ctx, query, namedArg)
+				return timeoutRows(ctx, cancel, timeout, rows, err)
+			}
+		},
+		ExecContextMiddleware: func(next ExecContextFunc) ExecContextFunc {
+			return func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+				ctx, cancel, timeout := withTimeout(ctx, options.timeout(ctx, query))
+				defer cancel()
+				result, err := next(ctx, query, namedArg)
+				return result, timeoutError(ctx, timeout, err)
+			}
+		},
+		NewStmtQueryContextMiddleware: func(query string) (StmtQueryContextMiddleware, error) {
+			return func(next StmtQueryContextFunc) StmtQueryContextFunc {
+				return func(ctx context.Context, namedArg []driver.NamedValue) (driver.Rows, error) {
+					ctx, cancel, timeout := withTimeout(ctx, options.timeout(ctx, query))
+					rows, err := next(ctx, namedArg)
+					return timeoutRows(ctx, cancel, timeout, rows, err)
+				}
+			}, nil
+		},
+		NewStmtExecContextMiddleware: func(query string) (StmtExecContextMiddleware, error) {
+			return func(next StmtExecContextFunc) StmtExecContextFunc {
+				return func(ctx context.Context, namedArg []driver.NamedValue) (driver.Result, error) {
+					ctx, cancel, timeout := withTimeout(ctx, options.timeout(ctx, query))
+					defer cancel()
+					result, err := next(ctx, namedArg)
+					return result, timeoutError(ctx, timeout, err)
+				}
+			}, nil
+		},
+	}
+}
+
+// originalQuery returns the query before any middleware rewrote it.
+func originalQuery(ctx context.Context, query string) string {
+	info, ok := OperationInfoFromContext(ctx)
+	if ok && info.Query != "" {
+		return info.Query
+	}
+	return query
+}
+
+// withTimeout applies timeout to ctx if it has no deadline, the returned timeout is zero if not applied.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc, time.Duration) {
+	if timeout <= 0 {
+		return ctx, func() {}, 0
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}, 0
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, timeout
+}
+
+func timeoutError(ctx context.Context, timeout time.Duration, err error) error {
+	if err == nil || err == io.EOF || timeout == 0 || ctx.Err() != context.DeadlineExceeded {
+		return err
+	}
+	return &TimeoutError{
+		Timeout: timeout,
+		Err:     err,
+	}
+}
+
+func timeoutRows(ctx context.Context, cancel context.CancelFunc, timeout time.Duration, rows driver.Rows, err error) (driver.Rows, error) {
+	if err != nil {
+		cancel()
+		return nil, timeoutError(ctx, timeout, err)
+	}
+	if timeout == 0 {
+		return rows, nil
+	}
+	return &cancelRows{
+		Rows:    rows,
+		ctx:     ctx,
+		cancel:  cancel,
+		timeout: timeout,
+	}, nil
+}
+
+// cancelRows calls cancel when closed, to release the context or the resources of a query.
+type cancelRows struct {
+	driver.Rows
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timeout time.Duration
+}
+
+// Close implements Rows.
+func (rows *cancelRows) Close() error {
+	defer rows.cancel()
+	return rows.Rows.Close()
+}
+
+// Next implements Rows.
+func (rows *cancelRows) Next(dest []driver.Value) error {
+	return timeoutError(rows.ctx, rows.timeout, rows.Rows.Next(dest))
+}
+
+// HasNextResultSet implements RowsNextResultSet.
+func (rows *cancelRows) HasNextResultSet() bool {
+	nextResultSet, ok := rows.Rows.(driver.RowsNextResultSet)
+	return ok && nextResultSet.HasNextResultSet()
+}
+
+// NextResultSet implements RowsNextResultSet.
+func (rows *cancelRows) NextResultSet() error {
+	nextResultSet, ok := rows.Rows.(driver.RowsNextResultSet)
+	if !ok {
+		return errors.New("driver not support multiple result sets")
+	}
+	return timeoutError(rows.ctx, rows.timeout, nextResultSet.NextResultSet())
+}
+
+// ColumnTypeScanType implements RowsColumnTypeScanType.
+func (rows *cancelRows) ColumnTypeScanType(index int) reflect.Type {
+	scanType, ok := rows.Rows.(driver.RowsColumnTypeScanType)
+	if !ok {
+		return reflect.TypeOf(new(interface{})).Elem()
+	}
+	return scanType.ColumnTypeScanType(index)
+}
+
+// ColumnTypeDatabaseTypeName implements RowsColumnTypeDatabaseTypeName.
+func (rows *cancelRows) ColumnTypeDatabaseTypeName(index int) string {
+	typeName, ok := rows.Rows.(driver.RowsColumnTypeDatabaseTypeName)
+	if !ok {
+		return ""
+	}
+	return typeName.ColumnTypeDatabaseTypeName(index)
+}
+
+// ColumnTypeLength implements RowsColumnTypeLength.
+func (rows *cancelRows) ColumnTypeLength(index int) (int64, bool) {
+	length, ok := rows.Rows.(driver.RowsColumnTypeLength)
+	if !ok {
+		return 0, false
+	}
+	return length.ColumnTypeLength(index)
+}
+
+// ColumnTypeNullable implements RowsColumnTypeNullable.
+func (rows *cancelRows) ColumnTypeNullable(index int) (bool, bool) {
+	nullable, ok := rows.Rows.(driver.RowsColumnTypeNullable)
+	if !ok {
+		return false, false
+	}
+	return nullable.ColumnTypeNullable(index)
+}
+
+// ColumnTypePrecisionScale implements RowsColumnTypePrecisionScale.
+func (rows *cancelRows) ColumnTypePrecisionScale(index int) (int64, int64, bool) {
+	precisionScale, ok := rows.Rows.(driver.RowsColumnTypePrecisionScale)
+	if !ok {
+		return 0, 0, false
+	}
+	return precisionScale.ColumnTypePrecisionScale(index)
+}
@@ -0,0 +1,88 @@
+package middledriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wencan/middledriver/fingerprint"
+	"github.com/wencan/middledriver/internal/fakedriver"
+)
+
+func TestTimeoutMiddlewareGroup(t *testing.T) {
+	var queryCtx context.Context
+	stats := NewStats()
+	dri := Driver{
+		Target: fakedriver.FakeDriver{
+			ExpectedQueryContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Rows, error) {
+				queryCtx = ctx
+				return &fakedriver.FakeRows{ColumnNames: []string{"1"}}, nil
+			},
+			ExpectedExecContext: func(ctx context.Context, query string, namedArg []driver.NamedValue) (driver.Result, error) {
+				deadline, ok := ctx.Deadline()
+				if !ok {
+					return fakedriver.FakeResult{}, nil
+				}
+				if time.Until(deadline) > time.Second {
+					return nil, errors.New("want the deadline of the caller")
+				}
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		},
+		MiddlewareGroup: TimeoutMiddlewareGroup(TimeoutOptions{
+			Default: 10 * time.Millisecond,
+			Fingerprints: map[string]time.Duration{
+				fingerprint.Of("SELECT * FROM reports").String(): 0,
+			},
+		}),
+		Stats: stats,
+	}
+	sql.Register("test_timeout", dri)
+
+	db, err := sql.Open("test_timeout", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.TODO()
+
+	_, err = db.ExecContext(ctx, "SELECT 1")
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || !errors.Is(err, context.DeadlineExceeded) || timeoutErr.Timeout != 10*time.Millisecond {
+		t.Fatalf("want TimeoutError, got %v", err)
+	}
+	if timeouts := stats.Snapshot().Timeouts; timeouts != 1 {
+		t.Fatalf("want 1 timeout, got %d", timeouts)
+	}
+
+	// overridden by fingerprint
+	_, err = db.ExecContext(ctx, "SELECT * FROM reports")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the deadline of the caller is kept
+	callerCtx, cancel := context.WithTimeout(ctx, time.Hour)
+	defer cancel()
+	_, err = db.ExecContext(callerCtx, "SELECT 1")
+	if err == nil || err.Error() != "want the deadline of the caller" {
+		t.Fatalf("want the deadline of the caller, got %v", err)
+	}
+
+	// rows hold the deadline until closed
+	rows, err := db.QueryContext(ctx, "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := queryCtx.Deadline(); !ok || queryCtx.Err() != nil {
+		t.Fatalf("want live deadline while rows are open, got %v", queryCtx.Err())
+	}
+	rows.Close()
+	if queryCtx.Err() != context.Canceled {
+		t.Fatalf("want context released by closing rows, got %v", queryCtx.Err())
+	}
+}
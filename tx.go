@@ -0,0 +1,41 @@
+package middledriver
+
+import (
+	"database/sql/driver"
+	"sync/atomic"
+)
+
+// Tx is a transaction.
+type Tx struct {
+	target driver.Tx
+
+	conn Conn
+}
+
+func newTx(target driver.Tx, conn Conn) Tx {
+	conn.driver.Stats.txBegan()
+	atomic.StoreInt32(conn.inTx, 1)
+
+	return Tx{
+		target: target,
+		conn:   conn,
+	}
+}
+
+// Commit implements Tx.
+func (tx Tx) Commit() error {
+	err := tx.target.Commit()
+	atomic.StoreInt32(tx.conn.inTx, 0)
+	tx.conn.driver.Stats.txEnded()
+	tx.conn.driver.Stats.failed(err)
+	return err
+}
+
+// Rollback implements Tx.
+func (tx Tx) Rollback() error {
+	err := tx.target.Rollback()
+	atomic.StoreInt32(tx.conn.inTx, 0)
+	tx.conn.driver.Stats.txEnded()
+	tx.conn.driver.Stats.failed(err)
+	return err
+}